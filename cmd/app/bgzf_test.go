@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"os"
+	"strings"
+	"testing"
+)
+
+// bgzf writes data as BGZF blocks of at most blockSize uncompressed bytes plus the EOF block,
+// and returns the file bytes along with its .gzi index.
+func bgzf(t *testing.T, data []byte, blockSize int) ([]byte, []byte) {
+	t.Helper()
+	var out bytes.Buffer
+	var gzi []uint64
+	block := func(p []byte) {
+		start := out.Len()
+		zw, err := gzip.NewWriterLevel(&out, gzip.BestSpeed)
+		if err != nil {
+			t.Fatalf("gzip writer: %v", err)
+		}
+		zw.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+		if _, err := zw.Write(p); err != nil {
+			t.Fatalf("gzip write: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("gzip close: %v", err)
+		}
+		b := out.Bytes()
+		binary.LittleEndian.PutUint16(b[start+16:], uint16(out.Len()-start-1))
+	}
+	for off := 0; off < len(data); off += blockSize {
+		if off > 0 {
+			gzi = append(gzi, uint64(out.Len()), uint64(off))
+		}
+		block(data[off:min(off+blockSize, len(data))])
+	}
+	block(nil)
+
+	idx := binary.LittleEndian.AppendUint64(nil, uint64(len(gzi)/2))
+	for _, v := range gzi {
+		idx = binary.LittleEndian.AppendUint64(idx, v)
+	}
+	return out.Bytes(), idx
+}
+
+func TestBGZF_ParallelBlocks(t *testing.T) {
+	lines := randomLines(21, 60000)
+	data := []byte(strings.Join(lines, ""))
+	want, err := read.UniqueIPv4CountReader(bytes.NewReader(data), 8, 1, 1)
+	if err != nil {
+		t.Fatalf("plain: %v", err)
+	}
+
+	file, idx := bgzf(t, data, 4093) // odd block size: lines get cut across blocks
+	path := writeBytes(t, "ips.txt.gz", file)
+	count := func(name string) {
+		t.Helper()
+		for _, R := range []int{1, 2, 7} {
+			got, err := read.UniqueIPv4Count(path, 8, R, 1, 4)
+			if err != nil {
+				t.Fatalf("%s R=%d: %v", name, R, err)
+			}
+			if got != want {
+				t.Fatalf("%s R=%d: count=%d, want %d", name, R, got, want)
+			}
+		}
+	}
+
+	count("scan")
+	if err := os.WriteFile(path+".gzi", idx, 0o644); err != nil {
+		t.Fatalf("write gzi: %v", err)
+	}
+	count("index")
+
+	// an index that points into the middle of blocks is ignored
+	bad := append([]byte(nil), idx...)
+	for i := 8; i+16 <= len(bad); i += 16 {
+		binary.LittleEndian.PutUint64(bad[i:], binary.LittleEndian.Uint64(bad[i:])+5)
+	}
+	if err := os.WriteFile(path+".gzi", bad, 0o644); err != nil {
+		t.Fatalf("write gzi: %v", err)
+	}
+	count("stale index")
+
+	// so is one cut short or that can't be read: an index only saves the scan
+	for name, data := range map[string][]byte{"truncated index": idx[:len(idx)-5], "empty index": nil} {
+		if err := os.WriteFile(path+".gzi", data, 0o644); err != nil {
+			t.Fatalf("write gzi: %v", err)
+		}
+		count(name)
+	}
+	if err := os.Remove(path + ".gzi"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path+".gzi", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	count("unreadable index")
+}
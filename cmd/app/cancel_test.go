@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// noLeak fails the test unless the goroutine count drops back to before (exiting goroutines get a moment).
+func noLeak(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("goroutines leaked: %d before, %d after\n%s", before, runtime.NumGoroutine(), buf[:n])
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// bigCorpus writes a file large enough that counting it takes far longer than the test's cancel delay.
+func bigCorpus(t *testing.T) string {
+	t.Helper()
+	block := []byte(strings.Join(randomLines(9, 100000), "") + "\n")
+	return writeBytes(t, "big.txt", bytes.Repeat(block, 40))
+}
+
+func TestCancel_ErrorPathsDontLeak(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := gzipMembers(t, []byte(strings.Join(randomLines(3, 5000), "")))
+	truncated := writeBytes(t, "cut.gz", corrupt[:len(corrupt)/2])
+	cfg := read.Config{Shards: 8, Readers: 4, BufMB: 1, ProbeKB: 4}
+
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{"missing", filepath.Join(dir, "nope.txt")},
+		{"directory", dir},
+		{"truncated gzip", truncated},
+	} {
+		before := runtime.NumGoroutine()
+		if _, err := read.UniqueIPv4CountContext(context.Background(), tc.path, cfg); err == nil {
+			t.Fatalf("%s: no error", tc.name)
+		}
+		noLeak(t, before)
+	}
+
+	before := runtime.NumGoroutine()
+	data := faultCorpus()
+	ra := &faultyReaderAt{data: data, failAt: 900000, err: syscall.EIO}
+	if _, err := read.UniqueIPv4CountReaderAt(ra, int64(len(data)), "fake", cfg); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("faulty reader: err=%v", err)
+	}
+	noLeak(t, before)
+}
+
+func TestCancel_BeforeStart(t *testing.T) {
+	path := writeTempFile(t, "small.txt", []string{"1.1.1.1\n", "2.2.2.2\n"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	before := runtime.NumGoroutine()
+	if _, err := read.UniqueIPv4CountContext(ctx, path, read.Config{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err=%v, want context.Canceled", err)
+	}
+	if _, _, err := read.UniqueIPv4CountFilesContext(ctx, []string{path, path}, read.Config{PerFile: true}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("files: err=%v, want context.Canceled", err)
+	}
+	noLeak(t, before)
+}
+
+func TestCancel_MidRunEveryIOMode(t *testing.T) {
+	path := bigCorpus(t)
+	gz := writeBytes(t, "big.gz", gzipMembers(t, []byte(strings.Join(randomLines(5, 400000), "")), 1<<20, 2<<20, 4<<20))
+
+	modes := []read.IOMode{read.IOPread, read.IOMmap, read.IOURing}
+	if directSupported(t, path) {
+		modes = append(modes, read.IODirect)
+	}
+	run := func(name string, paths []string, cfg read.Config) {
+		t.Helper()
+		freeBitsets()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		before := runtime.NumGoroutine()
+		from := time.Now()
+		_, _, err := read.UniqueIPv4CountFilesContext(ctx, paths, cfg)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%s: err=%v, want context.DeadlineExceeded", name, err)
+		}
+		if took := time.Since(from); took > 5*time.Second {
+			t.Fatalf("%s: returned %s after the deadline", name, took)
+		}
+		noLeak(t, before)
+	}
+	for _, mode := range modes {
+		run(mode.String(), []string{path, path}, read.Config{Shards: 8, Readers: 4, BufMB: 1, IO: mode, PerFile: true})
+	}
+	run("gzip", []string{gz}, read.Config{Shards: 8, Readers: 4, BufMB: 1})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+)
+
+// compareMain prints the set algebra between two inputs, exporting one of the sets if asked to.
+func compareMain(args []string) {
+	var from = time.Now()
+
+	fs := newFlagSet("compare", "compare [flags] <A> <B>")
+	var in inputFlags
+	in.register(fs)
+	var (
+		flagExport    = fs.String("export", "", "write the IPs of -part in ascending order to this file (- for stdout)")
+		flagExportFmt = fs.String("export-format", "text", "export encoding: text | raw | varint | roaring (a portable Roaring bitmap)")
+		flagPart      = fs.String("part", "", "the set to export: union | a | b | both | a-b | b-a (to -export, stdout by default; union if only -export is given)")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts := in.options()
+	exportFmt, err := ipcount.ParseExportFormat(*flagExportFmt)
+	if err != nil {
+		fail(err)
+	}
+	part := ipcount.PartUnion
+	if *flagPart != "" {
+		if part, err = ipcount.ParsePart(*flagPart); err != nil {
+			fail(err)
+		}
+		if *flagExport == "" {
+			*flagExport = "-"
+		}
+	}
+	// A and B are what each of the two arguments expands to
+	a, b := in.inputs(fs.Args()[:1]), in.inputs(fs.Args()[1:])
+	ctx := signalContext()
+	counter, stopProgress := in.start(opts)
+
+	report := os.Stdout
+	if *flagExport == "-" {
+		report = os.Stderr // stdout carries the export
+	}
+	cmp, err := compareInputs(ctx, counter, a, b, *flagExport, exportFmt, part)
+	stopProgress()
+	if err != nil {
+		fail(err)
+	}
+	printSamples(append(cmp.LinesA.Samples, cmp.LinesB.Samples...))
+	_, _ = fmt.Fprintf(report, "A: %d, B: %d, both: %d, only in A: %d, only in B: %d.\n", cmp.A, cmp.B, cmp.Both, cmp.OnlyA, cmp.OnlyB)
+	if *flagExport != "" {
+		_, _ = fmt.Fprintf(report, "Exported %s: %d.\n", part, cmp.Exported)
+	}
+	printLines(report, "Lines of A", cmp.LinesA)
+	printLines(report, "Lines of B", cmp.LinesB)
+	_, _ = fmt.Fprintf(report, "Union: %d, Jaccard: %.6f, elapsed: %s.\n", cmp.Union(), cmp.Jaccard(), time.Since(from).String())
+}
+
+// compareInputs compares a and b, exporting their part to path if given ("-" for stdout), removing the file again if the run fails.
+func compareInputs(ctx context.Context, counter *ipcount.Counter, a, b []string, path string, format ipcount.ExportFormat, part ipcount.Part) (ipcount.Comparison, error) {
+	switch path {
+	case "":
+		return counter.Compare(ctx, a, b)
+	case "-":
+		return counter.CompareExport(ctx, a, b, os.Stdout, format, part)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return ipcount.Comparison{}, err
+	}
+	cmp, err := counter.CompareExport(ctx, a, b, f, format, part)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("export: %w", cerr)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return cmp, err
+}
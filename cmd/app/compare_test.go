@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCompare_CountsAndExportsEveryPart(t *testing.T) {
+	r := rand.New(rand.NewSource(181))
+	ipsA, ipsB := randomIPs(r, 60000), randomIPs(r, 40000)
+	ipsB = append(ipsB, ipsA[:15000]...) // a shared part beyond the dense ranges both draw from
+	setA, _ := setOf(ipsA)
+	setB, _ := setOf(ipsB)
+	var linesB []string
+	for _, ip := range ipsB {
+		linesB = append(linesB, ipcount.Addr(ip).String()+"\n")
+	}
+	linesB = append(linesB, "junk\n")
+	var linesA []string
+	for _, ip := range ipsA {
+		linesA = append(linesA, ipcount.Addr(ip).String()+"\n")
+	}
+	half := len(linesA) / 2
+	a := []string{writeTempFile(t, "a1.txt", linesA[:half]), writeBytes(t, "a2.txt.gz", gzipMembers(t, []byte(strings.Join(linesA[half:], ""))))}
+	b := []string{writeTempFile(t, "b.txt", linesB)}
+
+	parts := map[ipcount.Part]*ipcount.Set{
+		ipcount.PartUnion: setA.Union(setB),
+		ipcount.PartA:     setA,
+		ipcount.PartB:     setB,
+		ipcount.PartBoth:  setA.Intersect(setB),
+		ipcount.PartOnlyA: setA.Difference(setB),
+		ipcount.PartOnlyB: setB.Difference(setA),
+	}
+	check := func(name string, cmp ipcount.Comparison) {
+		t.Helper()
+		want := [5]uint64{setA.Len(), setB.Len(), parts[ipcount.PartBoth].Len(), parts[ipcount.PartOnlyA].Len(), parts[ipcount.PartOnlyB].Len()}
+		if got := [5]uint64{cmp.A, cmp.B, cmp.Both, cmp.OnlyA, cmp.OnlyB}; got != want {
+			t.Fatalf("%s: |A|, |B|, |A∩B|, |A\\B|, |B\\A| = %v, want %v", name, got, want)
+		}
+		if cmp.Union() != parts[ipcount.PartUnion].Len() || math.Abs(cmp.Jaccard()-float64(want[2])/float64(cmp.Union())) > 1e-12 {
+			t.Fatalf("%s: union %d, Jaccard %f", name, cmp.Union(), cmp.Jaccard())
+		}
+		if cmp.LinesA.Valid != uint64(len(ipsA)) || cmp.LinesB.Valid != uint64(len(ipsB)) || cmp.LinesB.Invalid != 1 {
+			t.Fatalf("%s: lines %+v, %+v", name, cmp.LinesA, cmp.LinesB)
+		}
+	}
+
+	for _, opts := range []ipcount.Options{{Readers: 3, Shards: 7}, {Readers: 2, Shards: 64, Layout: ipcount.LayoutRange}} {
+		freeBitsets()
+		c, err := ipcount.New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmp, err := c.Compare(context.Background(), a, b)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		check("compare", cmp)
+	}
+
+	c, err := ipcount.New(ipcount.Options{Readers: 4, Shards: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formats := []ipcount.ExportFormat{ipcount.ExportText, ipcount.ExportRaw, ipcount.ExportVarint}
+	for part, want := range parts {
+		for _, format := range []ipcount.ExportFormat{formats[int(part)%len(formats)], ipcount.ExportRoaring} {
+			freeBitsets()
+			var out bytes.Buffer
+			cmp, err := c.CompareExport(context.Background(), a, b, &out, format, part)
+			if err != nil {
+				t.Fatalf("%s %s: %v", part, format, err)
+			}
+			check(part.String(), cmp)
+			var got []uint32
+			if format == ipcount.ExportRoaring {
+				set, err := ipcount.ReadRoaring(&out)
+				if err != nil {
+					t.Fatalf("%s: %v", part, err)
+				}
+				got = slices.Collect(set.All())
+			} else {
+				got = decodeExport(t, out.Bytes(), format)
+			}
+			if cmp.Exported != want.Len() || !slices.Equal(got, slices.Collect(want.All())) {
+				t.Fatalf("%s %s: exported %d (%d read back), want %d", part, format, cmp.Exported, len(got), want.Len())
+			}
+		}
+	}
+}
+
+func TestCompare_EmptySidesAndErrors(t *testing.T) {
+	empty := writeTempFile(t, "empty.txt", nil)
+	some := writeTempFile(t, "some.txt", []string{"1.1.1.1\n", "2.2.2.2\n", "bad\n"})
+	c, err := ipcount.New(ipcount.Options{Readers: 2, Shards: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmp, err := c.Compare(context.Background(), []string{empty}, []string{empty})
+	if err != nil || cmp.Union() != 0 || cmp.Jaccard() != 1 {
+		t.Fatalf("both empty: %+v, %v", cmp, err)
+	}
+	var out bytes.Buffer
+	cmp, err = c.CompareExport(context.Background(), []string{empty}, []string{some}, &out, ipcount.ExportText, ipcount.PartOnlyB)
+	if err != nil || cmp.OnlyB != 2 || cmp.Jaccard() != 0 || out.String() != "1.1.1.1\n2.2.2.2\n" {
+		t.Fatalf("one empty: %+v, %v, %q", cmp, err, out.String())
+	}
+
+	if _, err := c.Compare(context.Background(), nil, []string{some}); err != ipcount.ErrNoInput {
+		t.Fatalf("no A: %v", err)
+	}
+	if _, err := c.CompareExport(context.Background(), []string{some}, []string{some}, &out, ipcount.ExportText, 9); err == nil {
+		t.Fatal("part 9 accepted")
+	}
+	if _, err := ipcount.ParsePart("a\\b"); err == nil {
+		t.Fatal(`ParsePart accepted "a\b"`)
+	}
+	if p, err := ipcount.ParsePart("b-a"); err != nil || p != ipcount.PartOnlyB {
+		t.Fatalf("ParsePart(b-a) = %v, %v", p, err)
+	}
+	_, err = c.CompareExport(context.Background(), []string{some}, []string{some}, &failingWriter{}, ipcount.ExportText, ipcount.PartA)
+	if !errors.Is(err, errDiskFull) || !strings.HasPrefix(err.Error(), "export:") {
+		t.Fatalf("write error: %v", err)
+	}
+
+	strict, err := ipcount.New(ipcount.Options{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var le *ipcount.LineError
+	if _, err := strict.Compare(context.Background(), []string{empty}, []string{some}); !errors.As(err, &le) || le.Path != some {
+		t.Fatalf("strict: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out.Reset()
+	if _, err := c.CompareExport(ctx, []string{some}, []string{some}, &out, ipcount.ExportText, ipcount.PartUnion); !errors.Is(err, context.Canceled) || out.Len() != 0 {
+		t.Fatalf("cancelled: %v, %d bytes", err, out.Len())
+	}
+}
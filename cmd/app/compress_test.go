@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// two concatenated bzip2 streams (split mid-line) of 1000 lines "i%7.i%5.i%3.i%11", i in [0,1000)
+const bzip2Sample = "QlpoOTFBWSZTWfJbpxAABRhYAAAQAAF/4GAHoAHmeefe70883Vc9yXeAAAiqH7abKqIg/eynqqgCqf7FVBpiD9+KaqgCKp/+RFU8gJT9SqIyM979o7n1+d6/JfpnA7odYysgDFixzrIg6ry9MSWoLnB2q8TJu33bra8PRisYEmTnnRVyBNwr21BmId6sAxXyEtYMJCROJuFZa+Fzg7UksrozFe8EqYD5DrGVkAZq8QyVm7d3pgnbtesxB1DgYaYA+CAPL0Yq7oQzq86qsCbhXty+9d5vJtdMAfLAMlYAqwJMK8D5XOsmteIOrMV713eb0liRY5wcqqwOmA9oAzJb2o8TAHUO9WVM4HwQB4kJCS5EmleIOrMST3MqY6upLXawwg+WASV7fN89933fPN3O93qf+IKgKCIOCY4jggiCmKhimzy2WRrWysJOkWKKjjRbcSBESbdBxYfZEMN2QXdu7rskgEya0Sa3qeFZ8UM+8r4oZCvYNkueO2WtJjOkM6ec/BCYlmEhoRmUiJqQmGxMrKzihkJqSIlmEaGxMpENlgaB8t58csZjZbY1mlmzYwzBwTFDBsjLFsrKNbtka9vc7KnOnnK6Q2TaDnhWfFG4mc91WuQOIk24k1oncI4zhENeQiGSIl966l3HdtpMZ0hnTzldXuSYbxMrKz4oGgWpCYVnE0JEMkQ0UhMNiZnEzigaKRkiJZ4bPDExmGNmy0zWNrNjMWsY2bLTLY2s2MxbY1mlvA+8UMhMK8wmudnYJ085WTajcQ7k3DjOcJebbhKqEzpDsnOV60pA7xM+KD3pZQTZHHgsKdupjZJNaJkAkNi2gbxM+JmfFDJENCRMJDMziZRkJqRkKxMrKzCQyS0pETCQ2duGNw4YwQRAQVDFFHEFxFOL02ybvXIvIBJq0SATu3clu46g5NujcQHMgQ+UXd1UHJu0TJJ0dnLs6BlRRxBUEBEHBMcRMQRBTHRrrb07p3WSTrHuenUCInXcI9qmM2iQCZNaJHnkKNu7mV60rk5wc6WvfEe8axmNltjWaWbNjDbLSYzDGzZaZbG1mxmLbGsyzYzGmWs0xmGNsWzZrA2W2LZpZs2MMxplrMsZbZaZbG1jLMW2NZpZs2MfPAEgSAIAABIRJIEIASIRIASCrvlylypc6rlylzuXO6qXOXKlzrunVO6l1Ou66XKXO5c6rlylypcrqlylzuXK7pdTup1Tuq7lynSpc6rlylzuXO6qXKXKlzrunVLqXUuu6lyly5c7u5c5cqdOu5cpcqXLrndUru5c5cqdOu5cpcqXLu50pcuXO65XUq7lXdKu5V3Srrr6LuSKcKEh5LdOIEJaaDkxQVkmU1lJHOxVAASmWAAAEAABf+BQBQAbFCRKuHgAiqb9NhqqIVU/8ymqoGEqqf+0U1UDCVT96qpoZNAqaqhgh+T/T7+M+/lVGoAhXzUKPOfNEAsVVdDZrWIoId9JAH1jjAaqihgKtuPQPZ87qpAIxV2UGuikwBqtTWXwr7pCj7zHZKKpRTwDG86QoyT0ZQeKyRgL5wq/WjVedIUeKtQoEY63QGR2S4fF2lUKTG40UCQaroEJI74FVHigC+K9EUGR13A6qOSeBCrxSAumpMA9Ozd3d3c3d3NIRIRIQZ7rdm7P5LP319a87pO9Odp5yMEfdOR51ne7ddZtru4EUnmtVgPp1Ve9luC7SbaV51BFVUFFVQYCKpVYFFaiQLtNbtLWl1usxiESEYQVRUVvrVFRBeZheu6TtrbtOu07kEq2hTZmY3tJtrN1NcoPrVBa6TgTYvWdpN2k2tcUYlIK1EKQEEgUUFpAEoxaAlRSkuzbpUhEhBVFUVFUREVRUVRVEhEhBI7Wa62+z633SZ0m06647rwihel7niavTrrrNpQ2gLX1iiiXT2q86x7WdpNuvuqIJSApBawKLRWkEUVRlut222u1l2u211dESQSESEGEFUVFNNb7u8t9J7ueZxzZb7dZma9d7addFpzxMtm0669dXz7W3yqKiqJIJCP1dm+vdJd55nenM5Rhx2Khd7bXWZCXu3avnp112m+e+YJay1lrQQTWWtBCCCWstoIJay1prQTWWtNaCEEtpbIQWTS1lrTabzjW22txrW22qkkklVUlSVUkrbfiWiLNolrLWMi0Raza2Wy2TM0S1kW20WiLQy0iy0bWyNbLNhEiLWbRIstbMtIsi2a2RrZbbZEia0xa0RFsWkRFhNlmLWiIjLSIi2IlpottNlsLbNbCbNYzfxdyRThQkEkc7FU="
+
+// gzipMembers compresses data as one gzip member per part, cutting at the given byte offsets.
+func gzipMembers(t *testing.T, data []byte, cuts ...int) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	prev := 0
+	for _, c := range append(cuts, len(data)) {
+		zw := gzip.NewWriter(&out)
+		if _, err := zw.Write(data[prev:c]); err != nil {
+			t.Fatalf("gzip write: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("gzip close: %v", err)
+		}
+		prev = c
+	}
+	return out.Bytes()
+}
+
+func writeBytes(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestCompressed_GzipSingleAndMultiMember(t *testing.T) {
+	lines := randomLines(11, 60000)
+	plain := writeTempFile(t, "plain.txt", lines)
+	want, err := read.UniqueIPv4Count(plain, 8, 1, 1, 4)
+	if err != nil {
+		t.Fatalf("plain: %v", err)
+	}
+	data := []byte(strings.Join(lines, ""))
+
+	// cuts land mid-line, on a '\n' and right after one
+	nl := bytes.IndexByte(data[300000:], '\n') + 300000
+	var cuts []int
+	for c := 7; c < len(data); c += 9973 {
+		cuts = append(cuts, c)
+	}
+	cases := map[string][]byte{
+		"single.gz": gzipMembers(t, data),
+		"multi.gz":  gzipMembers(t, data, cuts...),
+		"edges.log": gzipMembers(t, data, 1, nl, nl+1, len(data)-1),
+	}
+	for name, gz := range cases {
+		path := writeBytes(t, name, gz)
+		for _, R := range []int{1, 3, 8} {
+			got, err := read.UniqueIPv4Count(path, 8, R, 1, 4)
+			if err != nil {
+				t.Fatalf("%s R=%d: %v", name, R, err)
+			}
+			if got != want {
+				t.Fatalf("%s R=%d: count=%d, want %d", name, R, got, want)
+			}
+		}
+		got, err := read.UniqueIPv4CountReader(bytes.NewReader(gz), 8, 2, 1)
+		if err != nil {
+			t.Fatalf("%s stream: %v", name, err)
+		}
+		if got != want {
+			t.Fatalf("%s stream: count=%d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestCompressed_GzipMembersFarFromSplits(t *testing.T) {
+	lines := randomLines(13, 20000)
+	plain := writeTempFile(t, "plain.txt", lines)
+	want, err := read.UniqueIPv4Count(plain, 8, 1, 1, 4)
+	if err != nil {
+		t.Fatalf("plain: %v", err)
+	}
+	// a stored member of 9 MiB of blank lines: the next member starts past the scan of the splits before it
+	var out bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&out, gzip.NoCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(bytes.Repeat([]byte{'\n'}, 9<<20)); err != nil || zw.Close() != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	out.Write(gzipMembers(t, []byte(strings.Join(lines, "")), 5000))
+	path := writeBytes(t, "far.gz", out.Bytes())
+	for _, R := range []int{2, 8} {
+		got, err := read.UniqueIPv4Count(path, 8, R, 1, 4)
+		if err != nil || got != want {
+			t.Fatalf("R=%d: count=%d, %v; want %d", R, got, err, want)
+		}
+	}
+}
+
+func TestCompressed_GzipCorrupt(t *testing.T) {
+	data := []byte(strings.Join(randomLines(12, 20000), ""))
+	gz := gzipMembers(t, data, len(data)/2)
+	gz = gz[:len(gz)-100]
+	path := writeBytes(t, "broken.gz", gz)
+	if _, err := read.UniqueIPv4Count(path, 4, 2, 1, 4); err == nil {
+		t.Fatalf("expected error for truncated gzip")
+	}
+}
+
+func TestCompressed_Bzip2AndZlib(t *testing.T) {
+	bz, err := base64.StdEncoding.DecodeString(bzip2Sample)
+	if err != nil {
+		t.Fatalf("decode sample: %v", err)
+	}
+	got, err := read.UniqueIPv4Count(writeBytes(t, "sample.bz2", bz), 4, 2, 1, 4)
+	if err != nil {
+		t.Fatalf("bzip2: %v", err)
+	}
+	if got != 1000 {
+		t.Fatalf("bzip2: count=%d, want 1000", got)
+	}
+
+	data := []byte(strings.Join(randomLines(13, 20000), ""))
+	want, err := read.UniqueIPv4CountReader(bytes.NewReader(data), 4, 1, 1)
+	if err != nil {
+		t.Fatalf("plain: %v", err)
+	}
+	var zb bytes.Buffer
+	zw := zlib.NewWriter(&zb)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+	got, err = read.UniqueIPv4Count(writeBytes(t, "sample.zz", zb.Bytes()), 4, 2, 1, 4)
+	if err != nil {
+		t.Fatalf("zlib: %v", err)
+	}
+	if got != want {
+		t.Fatalf("zlib: count=%d, want %d", got, want)
+	}
+}
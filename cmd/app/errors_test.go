@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"io"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// faultyReaderAt serves data but fails reads touching [failAt, ...) with err.
+// With transient > 0 only that many failing reads happen, later ones succeed.
+type faultyReaderAt struct {
+	data      []byte
+	failAt    int64
+	err       error
+	transient int
+
+	mu    sync.Mutex
+	fails int
+}
+
+func (f *faultyReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if off <= f.failAt && f.failAt < end {
+		f.mu.Lock()
+		fail := f.transient == 0 || f.fails < f.transient
+		f.fails++
+		f.mu.Unlock()
+		if fail {
+			// deliver what precedes the fault, like a real partial read
+			return copy(p, f.data[off:f.failAt]), f.err
+		}
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// stuckReaderAt reports success without reading anything.
+type stuckReaderAt struct{}
+
+func (stuckReaderAt) ReadAt([]byte, int64) (int, error) { return 0, nil }
+
+func faultCorpus() []byte {
+	return []byte(strings.Join(randomLines(41, 120000), ""))
+}
+
+func faultCfg() read.Config {
+	return read.Config{Shards: 4, Readers: 3, BufMB: 1, ProbeKB: 4}
+}
+
+func TestFaults_PermanentErrorCarriesPathAndOffset(t *testing.T) {
+	data := faultCorpus()
+	const failAt = 1500000
+	ra := &faultyReaderAt{data: data, failAt: failAt, err: syscall.EIO}
+
+	_, err := read.UniqueIPv4CountReaderAt(ra, int64(len(data)), "fake.txt", faultCfg())
+	var re *read.ReadError
+	if !errors.As(err, &re) {
+		t.Fatalf("err=%v, want *read.ReadError", err)
+	}
+	if re.Path != "fake.txt" || re.Offset != failAt || !errors.Is(err, syscall.EIO) {
+		t.Fatalf("got path=%q offset=%d err=%v, want fake.txt at %d with EIO", re.Path, re.Offset, re.Err, failAt)
+	}
+}
+
+func TestFaults_ProbeError(t *testing.T) {
+	data := faultCorpus()
+	// the second segment starts at len/3: its alignment probe hits the fault
+	failAt := int64(len(data)/3) + 10
+	ra := &faultyReaderAt{data: data, failAt: failAt, err: syscall.EIO}
+
+	_, err := read.UniqueIPv4CountReaderAt(ra, int64(len(data)), "probe.txt", faultCfg())
+	var re *read.ReadError
+	if !errors.As(err, &re) || re.Offset != failAt {
+		t.Fatalf("err=%v, want *read.ReadError at offset %d", err, failAt)
+	}
+}
+
+func TestFaults_TransientErrorsRetried(t *testing.T) {
+	data := faultCorpus()
+	want, err := read.UniqueIPv4CountReaderAt(&faultyReaderAt{data: data, failAt: -1}, int64(len(data)), "clean", faultCfg())
+	if err != nil {
+		t.Fatalf("clean: %v", err)
+	}
+
+	for _, errno := range []error{syscall.EINTR, syscall.EAGAIN} {
+		ra := &faultyReaderAt{data: data, failAt: 700001, err: errno, transient: 2}
+		if _, err := read.UniqueIPv4CountReaderAt(ra, int64(len(data)), "noretry", faultCfg()); !errors.Is(err, errno) {
+			t.Fatalf("%v without retries: err=%v", errno, err)
+		}
+
+		cfg := faultCfg()
+		cfg.Retry = read.RetryPolicy{Attempts: 3, Backoff: time.Microsecond}
+		ra = &faultyReaderAt{data: data, failAt: 700001, err: errno, transient: 2}
+		got, err := read.UniqueIPv4CountReaderAt(ra, int64(len(data)), "retry", cfg)
+		if err != nil {
+			t.Fatalf("%v with retries: %v", errno, err)
+		}
+		if got != want {
+			t.Fatalf("%v with retries: count=%d, want %d", errno, got, want)
+		}
+	}
+
+	// a budget smaller than the number of failures still surfaces the error
+	cfg := faultCfg()
+	cfg.Retry = read.RetryPolicy{Attempts: 1}
+	ra := &faultyReaderAt{data: data, failAt: 700001, err: syscall.EAGAIN, transient: 5}
+	if _, err := read.UniqueIPv4CountReaderAt(ra, int64(len(data)), "budget", cfg); !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("exhausted retries: err=%v", err)
+	}
+}
+
+func TestFaults_NoProgressAndTruncation(t *testing.T) {
+	done := make(chan error, 1)
+	go func() {
+		_, err := read.UniqueIPv4CountReaderAt(stuckReaderAt{}, 1<<20, "stuck", faultCfg())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, io.ErrNoProgress) {
+			t.Fatalf("stuck reader: err=%v, want io.ErrNoProgress", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("stuck reader: count never returned")
+	}
+
+	// the source is shorter than the size the segments were cut for
+	data := faultCorpus()
+	_, err := read.UniqueIPv4CountReaderAt(&faultyReaderAt{data: data, failAt: -1}, int64(len(data))+4096, "short", faultCfg())
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated source: err=%v, want io.ErrUnexpectedEOF", err)
+	}
+}
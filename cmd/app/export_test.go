@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/Borislavv/ip-file-counter/internal/codec"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"math/rand"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// decodeExport reads an export back into addresses.
+func decodeExport(t *testing.T, data []byte, format ipcount.ExportFormat) []uint32 {
+	t.Helper()
+	var out []uint32
+	switch format {
+	case ipcount.ExportRaw:
+		if len(data)%4 != 0 {
+			t.Fatalf("raw export of %d bytes", len(data))
+		}
+		for i := 0; i < len(data); i += 4 {
+			out = append(out, binary.BigEndian.Uint32(data[i:]))
+		}
+	case ipcount.ExportVarint:
+		r := bytes.NewReader(data)
+		var prev uint64
+		for r.Len() > 0 {
+			gap, err := binary.ReadUvarint(r)
+			if err != nil {
+				t.Fatalf("varint export: %v", err)
+			}
+			prev += gap
+			out = append(out, uint32(prev))
+		}
+	default:
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			ip, ok := codec.ParseIPv4(sc.Bytes())
+			if !ok || string(codec.AppendIPv4(nil, ip)) != sc.Text() {
+				t.Fatalf("text export line %q", sc.Text())
+			}
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+func TestExport_AllFormatsAscending(t *testing.T) {
+	r := rand.New(rand.NewSource(91))
+	ips := append(randomIPs(r, 150000), 0, 0xFFFFFFFF, 0x7FFFFFFF, 0x80000000)
+	lines := []string{"junk\n"}
+	ref := make(map[uint32]bool)
+	for _, ip := range ips {
+		lines = append(lines, ipcount.Addr(ip).String()+"\n")
+		ref[ip] = true
+	}
+	want := make([]uint32, 0, len(ref))
+	for ip := range ref {
+		want = append(want, ip)
+	}
+	slices.Sort(want)
+	half := len(lines) / 2
+	paths := []string{writeTempFile(t, "a.txt", lines[:half]), writeTempFile(t, "b.txt", lines[half:])}
+
+	for _, shards := range []int{1, 7, 256} {
+		c, err := ipcount.New(ipcount.Options{Shards: shards, Readers: 3, BufMB: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, format := range []ipcount.ExportFormat{ipcount.ExportText, ipcount.ExportRaw, ipcount.ExportVarint} {
+			var out bytes.Buffer
+			res, err := c.ExportFiles(context.Background(), paths, &out, format)
+			if err != nil {
+				t.Fatalf("shards=%d %s: %v", shards, format, err)
+			}
+			if res.Unique != uint64(len(want)) {
+				t.Fatalf("shards=%d %s: unique=%d, want %d", shards, format, res.Unique, len(want))
+			}
+			if got := decodeExport(t, out.Bytes(), format); !slices.Equal(got, want) {
+				t.Fatalf("shards=%d %s: exported %d addresses, want %d ascending", shards, format, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestExport_EmptyAndFormats(t *testing.T) {
+	path := writeTempFile(t, "empty.txt", []string{"junk\n", "\n"})
+	c, err := ipcount.New(ipcount.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if res, err := c.ExportFiles(context.Background(), []string{path}, &out, ipcount.ExportText); err != nil || res.Unique != 0 || out.Len() != 0 {
+		t.Fatalf("unique=%d, %d bytes exported, err=%v", res.Unique, out.Len(), err)
+	}
+
+	for _, name := range []string{"text", "raw", "varint"} {
+		f, err := ipcount.ParseExportFormat(name)
+		if err != nil || f.String() != name {
+			t.Fatalf("ParseExportFormat(%q) = %v, %v", name, f, err)
+		}
+	}
+	if _, err := ipcount.ParseExportFormat("csv"); err == nil {
+		t.Fatal("ParseExportFormat accepted csv")
+	}
+}
+
+type failingWriter struct{ after int }
+
+var errDiskFull = errors.New("disk full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.after -= len(p); w.after < 0 {
+		return 0, errDiskFull
+	}
+	return len(p), nil
+}
+
+func TestExport_WriteErrorAndCancel(t *testing.T) {
+	r := rand.New(rand.NewSource(93))
+	var lines []string
+	for _, ip := range randomIPs(r, 300000) {
+		lines = append(lines, ipcount.Addr(ip).String()+"\n")
+	}
+	path := writeTempFile(t, "many.txt", lines)
+	c, err := ipcount.New(ipcount.Options{Readers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	_, err = c.ExportFiles(context.Background(), []string{path}, &failingWriter{after: 3 << 20}, ipcount.ExportText)
+	if !errors.Is(err, errDiskFull) || !strings.HasPrefix(err.Error(), "export:") {
+		t.Fatalf("err=%v, want the write error", err)
+	}
+	noLeak(t, before)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	if _, err := c.ExportFiles(ctx, []string{path}, &out, ipcount.ExportRaw); !errors.Is(err, context.Canceled) || out.Len() != 0 {
+		t.Fatalf("cancelled: err=%v, %d bytes written", err, out.Len())
+	}
+}
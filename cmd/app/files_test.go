@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFileIn(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestExpandInputs_GlobsDirsRecursive(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFileIn(t, dir, "a.log", "1.1.1.1\n")
+	b := writeFileIn(t, dir, "b.log", "2.2.2.2\n")
+	c := writeFileIn(t, dir, "sub/c.log", "3.3.3.3\n")
+	n := writeFileIn(t, dir, "notes.txt", "4.4.4.4\n")
+	// log directories link to rotated files: a link to a regular file counts, dangling ones and ones to directories don't
+	l := filepath.Join(dir, "link.log")
+	for target, link := range map[string]string{c: l, filepath.Join(dir, "gone.log"): filepath.Join(dir, "dangling.log"), filepath.Join(dir, "sub"): filepath.Join(dir, "subdir")} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlink: %v", err)
+		}
+	}
+
+	cases := []struct {
+		args      []string
+		recursive bool
+		want      []string
+	}{
+		{[]string{filepath.Join(dir, "[ab].log")}, false, []string{a, b}},
+		{[]string{dir}, false, []string{a, b, l, n}},
+		{[]string{dir}, true, []string{a, b, l, n, c}},
+		{[]string{c, "-", a}, false, []string{c, "-", a}},
+	}
+	for i, tc := range cases {
+		got, err := read.ExpandInputs(tc.args, tc.recursive)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("case %d: got %v, want %v", i, got, tc.want)
+		}
+		for j := range got {
+			if got[j] != tc.want[j] {
+				t.Fatalf("case %d: got %v, want %v", i, got, tc.want)
+			}
+		}
+	}
+
+	if _, err := read.ExpandInputs([]string{filepath.Join(dir, "*.gz")}, false); err == nil {
+		t.Fatalf("expected error for glob without matches")
+	}
+}
+
+func TestUniqueIPv4CountFiles_PerFile(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		writeFileIn(t, dir, "h1.log", "1.1.1.1\n2.2.2.2\n1.1.1.1\n"),
+		writeFileIn(t, dir, "h2.log", "2.2.2.2\r\n3.3.3.3\n4.4.4.4"),
+		writeFileIn(t, dir, "h3.log", "4.4.4.4\n1.1.1.1\n"),
+		writeFileIn(t, dir, "h4.log", ""),
+	}
+	want := []read.FileCount{
+		{Path: paths[0], Unique: 2, Added: 2},
+		{Path: paths[1], Unique: 3, Added: 2},
+		{Path: paths[2], Unique: 2, Added: 0},
+		{Path: paths[3], Unique: 0, Added: 0},
+	}
+
+	// the empty last file must not inherit the counts of the one before, whatever reads it
+	modes := []read.IOMode{read.IOPread, read.IOMmap, read.IOURing}
+	if directSupported(t, paths[0]) {
+		modes = append(modes, read.IODirect)
+	}
+	for _, mode := range modes {
+		freeBitsets()
+		total, files, err := read.UniqueIPv4CountFiles(paths, read.Config{Shards: 8, Readers: 2, BufMB: 1, ProbeKB: 1, IO: mode, PerFile: true})
+		if err != nil {
+			t.Fatalf("io=%s: count files: %v", mode, err)
+		}
+		if total != 4 {
+			t.Fatalf("io=%s: total=%d, want 4", mode, total)
+		}
+		for i := range want {
+			if files[i] != want[i] {
+				t.Fatalf("io=%s: file %d: got %+v, want %+v", mode, i, files[i], want[i])
+			}
+		}
+	}
+
+	total, files, err := read.UniqueIPv4CountFiles(paths, read.Config{Shards: 8, Readers: 2, BufMB: 1, ProbeKB: 1})
+	if err != nil {
+		t.Fatalf("count files: %v", err)
+	}
+	if total != 4 || files != nil {
+		t.Fatalf("total=%d files=%v, want 4 and no breakdown", total, files)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// filterMain writes the first line of each IP of its inputs to stdout, in input order, and reports on stderr.
+func filterMain(args []string) {
+	var from = time.Now()
+
+	fs := newFlagSet("filter", "filter [flags] <path | glob | dir | ->...")
+	var in inputFlags
+	in.register(fs)
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts := in.options()
+	paths := in.inputs(fs.Args())
+	ctx := signalContext()
+	counter, stopProgress := in.start(opts)
+
+	res, err := counter.Filter(ctx, paths, os.Stdout)
+	stopProgress()
+	if err != nil {
+		fail(err)
+	}
+	st := res.Lines
+	printSamples(st.Samples)
+	_, _ = fmt.Fprintf(os.Stderr, "Kept: %d lines, dropped repeats: %d.\n", res.Unique, st.Valid-res.Unique)
+	printLines(os.Stderr, "Lines", st)
+	_, _ = fmt.Fprintf(os.Stderr, "Unique IPv4 Count: %d, elapsed: %s.\n", res.Unique, time.Since(from).String())
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Borislavv/ip-file-counter/internal/codec"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// refFilter keeps the first line of every address, its line ending included, like awk '!seen[$0]++'
+// over parsed addresses; it returns the output and the lines kept.
+func refFilter(inputs ...[]string) (string, uint64) {
+	seen := make(map[uint32]bool)
+	var out strings.Builder
+	var kept uint64
+	for _, lines := range inputs {
+		for _, line := range lines {
+			ip, ok := codec.ParseIPv4([]byte(strings.TrimSuffix(line, "\n")))
+			if !ok || seen[ip] {
+				continue
+			}
+			seen[ip] = true
+			kept++
+			out.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				out.WriteByte('\n')
+			}
+		}
+	}
+	return out.String(), kept
+}
+
+// filterCorpus has repeats near and far apart, CRLF endings, junk and blank lines.
+func filterCorpus(seed int64, n int) []string {
+	r := rand.New(rand.NewSource(seed))
+	pool := randomIPs(r, n/3)
+	lines := make([]string, 0, n)
+	for range n {
+		ip := ipcount.Addr(pool[r.Intn(len(pool))]).String()
+		switch r.Intn(50) {
+		case 0:
+			lines = append(lines, "junk\n")
+		case 1:
+			lines = append(lines, "\n")
+		case 2:
+			lines = append(lines, ip+"\r\n")
+		default:
+			lines = append(lines, ip+"\n")
+		}
+	}
+	return lines
+}
+
+func TestFilter_KeepsFirstLinesInOrder(t *testing.T) {
+	a, b := filterCorpus(141, 300000), filterCorpus(142, 100000)
+	b[len(b)-1] = "9.9.9.9" // unterminated, and new
+	want, kept := refFilter(a, b)
+	paths := []string{
+		writeTempFile(t, "a.txt", a),
+		writeBytes(t, "b.txt.gz", gzipMembers(t, []byte(strings.Join(b, "")), 100000)),
+	}
+	for _, opts := range []ipcount.Options{{Readers: 1}, {Readers: 4, BufMB: 1}, {Readers: 7, BufMB: 1, Samples: 3}} {
+		c, err := ipcount.New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		res, err := c.Filter(context.Background(), paths, &out)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if res.Unique != kept || out.String() != want {
+			t.Fatalf("%+v: kept %d lines (%d bytes), want %d (%d bytes)", opts, res.Unique, out.Len(), kept, len(want))
+		}
+		if st := res.Lines; st.Lines != uint64(len(a)+len(b)) || st.Valid+st.Invalid+st.Blank != st.Lines || st.Invalid == 0 {
+			t.Fatalf("%+v: lines %+v", opts, st)
+		}
+	}
+}
+
+func TestFilter_ErrorsAndCancel(t *testing.T) {
+	path := writeTempFile(t, "many.txt", filterCorpus(143, 300000))
+	c, err := ipcount.New(ipcount.Options{Readers: 3, BufMB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Filter(context.Background(), nil, &bytes.Buffer{}); err != ipcount.ErrNoInput {
+		t.Fatalf("no paths: %v", err)
+	}
+	before := runtime.NumGoroutine()
+	_, err = c.Filter(context.Background(), []string{path}, &failingWriter{after: 256 << 10})
+	if !errors.Is(err, errDiskFull) || !strings.HasPrefix(err.Error(), "filter:") {
+		t.Fatalf("err=%v, want the write error", err)
+	}
+	noLeak(t, before)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	if _, err := c.Filter(ctx, []string{path}, &out); !errors.Is(err, context.Canceled) || out.Len() != 0 {
+		t.Fatalf("cancelled: err=%v, %d bytes written", err, out.Len())
+	}
+
+	strict, err := ipcount.New(ipcount.Options{Readers: 2, Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var le *ipcount.LineError
+	if _, err := strict.Filter(context.Background(), []string{path}, &out); !errors.As(err, &le) || le.Path != path {
+		t.Fatalf("strict: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// heavyCorpus has addresses repeated past both counter widths, a whole /16 to push an auto shard
+// dense under the range layout, and random traffic; it returns the lines and the exact counts.
+func heavyCorpus(seed int64) ([]string, map[uint32]uint64) {
+	r := rand.New(rand.NewSource(seed))
+	ref := make(map[uint32]uint64)
+	var lines []string
+	add := func(ip uint32, n int) {
+		for range n {
+			lines = append(lines, ipcount.Addr(ip).String()+"\n")
+		}
+		ref[ip] += uint64(n)
+	}
+	add(0x01010101, 70000) // past a 16-bit counter
+	add(0xC0A80001, 300)   // past an 8-bit counter
+	add(0xC0A80002, 300)   // a tie, ordered by address
+	add(0xFFFFFFFF, 255)
+	add(0x0A0B0001, 300) // a heavy hitter in the dense /16
+	for lo := range 1 << 16 {
+		add(0x0A0B0000|uint32(lo), 1+lo%3)
+	}
+	for _, ip := range randomIPs(r, 100000) {
+		add(ip, 1)
+	}
+	r.Shuffle(len(lines), func(i, j int) { lines[i], lines[j] = lines[j], lines[i] })
+	return lines, ref
+}
+
+func refTop(ref map[uint32]uint64, k int) []ipcount.IPCount {
+	all := make([]ipcount.IPCount, 0, len(ref))
+	for ip, n := range ref {
+		all = append(all, ipcount.IPCount{IP: ip, Count: n})
+	}
+	slices.SortFunc(all, func(a, b ipcount.IPCount) int {
+		if a.Count != b.Count {
+			return int(int64(b.Count) - int64(a.Count))
+		}
+		return int(int64(a.IP) - int64(b.IP))
+	})
+	return all[:min(k, len(all))]
+}
+
+func TestFrequencies_TopAndCountsEveryCounter(t *testing.T) {
+	lines, ref := heavyCorpus(121)
+	half := len(lines) / 2
+	paths := []string{writeTempFile(t, "a.txt", append(lines[:half:half], "junk\n")), writeTempFile(t, "b.txt", append(lines[half:], "junk\n"))}
+	r := rand.New(rand.NewSource(123))
+	var probes []uint32
+	for ip := range ref {
+		if len(probes) < 2000 {
+			probes = append(probes, ip, ip+1)
+		}
+	}
+	probes = append(probes, r.Uint32(), 0)
+
+	for _, opts := range []ipcount.Options{
+		{Readers: 3},
+		{Readers: 3, Shards: 1 << 16, Layout: ipcount.LayoutRange}, // the dense /16 turns its shard dense
+		{Readers: 2, Shards: 5, Counters: ipcount.CountersSparse},
+	} {
+		freeBitsets()
+		checkFrequencies(t, opts, paths, ref, probes)
+	}
+}
+
+// memAvailable returns the MemAvailable of /proc/meminfo in bytes, 0 if unknown.
+func memAvailable() uint64 {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var kb uint64
+		if _, err := fmt.Sscanf(line, "MemAvailable: %d kB", &kb); err == nil {
+			return kb << 10
+		}
+	}
+	return 0
+}
+
+func TestFrequencies_DenseCounters(t *testing.T) {
+	lines, ref := heavyCorpus(125)
+	paths := []string{writeTempFile(t, "dense.txt", append(lines, "junk\n"))}
+	probes := []uint32{0x01010101, 0xC0A80001, 0x0A0B0001, 0x0A0B0002, 0xFFFFFFFF, 0}
+	for _, tc := range []struct {
+		need uint64
+		opts ipcount.Options
+	}{
+		{6 << 30, ipcount.Options{Readers: 2, Shards: 64, Counters: ipcount.Counters8}},
+		{10 << 30, ipcount.Options{Readers: 2, Shards: 64, Counters: ipcount.Counters16, Layout: ipcount.LayoutRange}},
+	} {
+		if avail := memAvailable(); avail < tc.need {
+			t.Logf("counters %s: skipped, %d MiB available", tc.opts.Counters, avail>>20)
+			continue
+		}
+		checkFrequencies(t, tc.opts, paths, ref, probes)
+		freeBitsets()
+	}
+}
+
+// checkFrequencies runs Frequencies with opts and checks the unique count, top lists and probed counts against ref.
+func checkFrequencies(t *testing.T, opts ipcount.Options, paths []string, ref map[uint32]uint64, probes []uint32) {
+	t.Helper()
+	c, err := ipcount.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	freq, res, err := c.Frequencies(context.Background(), paths)
+	if err != nil {
+		t.Fatalf("%+v: %v", opts, err)
+	}
+	if res.Unique != uint64(len(ref)) || freq.Unique() != res.Unique || res.Lines.Invalid != uint64(len(paths)) {
+		t.Fatalf("%+v: unique=%d lines=%+v, want %d", opts, res.Unique, res.Lines, len(ref))
+	}
+	for _, k := range []int{1, 4, 50, 1000} {
+		if got, want := freq.Top(k), refTop(ref, k); !slices.Equal(got, want) {
+			t.Fatalf("%+v: Top(%d) = %v..., want %v...", opts, k, got[:min(4, len(got))], want[:min(4, len(want))])
+		}
+	}
+	for _, ip := range probes {
+		if got := freq.Count(ip); got != ref[ip] {
+			t.Fatalf("%+v: Count(%s) = %d, want %d", opts, ipcount.Addr(ip), got, ref[ip])
+		}
+	}
+}
+
+func TestFrequencies_EdgeCases(t *testing.T) {
+	c, err := ipcount.New(ipcount.Options{Shards: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Frequencies(context.Background(), nil); err != ipcount.ErrNoInput {
+		t.Fatalf("no paths: %v", err)
+	}
+	path := writeTempFile(t, "few.txt", []string{"1.1.1.1\n", "2.2.2.2\n", "1.1.1.1\n"})
+	freq, _, err := c.Frequencies(context.Background(), []string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ipcount.IPCount{{IP: 0x01010101, Count: 2}, {IP: 0x02020202, Count: 1}}
+	if got := freq.Top(10); !slices.Equal(got, want) || freq.Top(0) != nil {
+		t.Fatalf("Top(10) = %v, want %v", got, want)
+	}
+	for _, name := range []string{"auto", "sparse", "8", "16"} {
+		if got, err := ipcount.ParseCounters(name); err != nil || got.String() != name {
+			t.Fatalf("ParseCounters(%q) = %v, %v", name, got, err)
+		}
+	}
+	if _, err := ipcount.New(ipcount.Options{Counters: 42}); err == nil || !strings.Contains(err.Error(), "Counters") {
+		t.Fatalf("New with counters 42: %v", err)
+	}
+}
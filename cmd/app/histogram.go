@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+)
+
+// writeHistogram prints h either as JSON (format "json") or as one human-readable line per bucket.
+func writeHistogram(w io.Writer, h ipcount.Histogram, unique, lines uint64, format string) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(struct {
+			Unique uint64 `json:"unique"`
+			Lines  uint64 `json:"lines"`
+			ipcount.Histogram
+		}{unique, lines, h})
+	}
+	for _, b := range h.Buckets {
+		seen := fmt.Sprintf("%d-%d", b.Min, b.Max)
+		switch {
+		case b.Max == 0:
+			seen = fmt.Sprintf("%d+", b.Min)
+		case b.Min == b.Max:
+			seen = fmt.Sprint(b.Min)
+		}
+		times := "times"
+		if b.Max == 1 {
+			times = "time"
+		}
+		_, err := fmt.Fprintf(w, "Seen %s %s: %d IPs (%.1f%%), %d lines (%.1f%%).\n",
+			seen, times, b.IPs, percent(b.IPs, unique), b.Occurrences, percent(b.Occurrences, lines))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "Singletons: %d (%.1f%%), repeaters: %d (%.1f%%).\n",
+		h.Singletons, percent(h.Singletons, unique), h.Repeaters, percent(h.Repeaters, unique))
+	return err
+}
+
+func percent(n, of uint64) float64 {
+	if of == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(of)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// refHistogram buckets the reference counts by the given lower bounds.
+func refHistogram(ref map[uint32]uint64, bounds []uint64) ipcount.Histogram {
+	h := ipcount.Histogram{Buckets: make([]ipcount.Bucket, len(bounds))}
+	for i, lo := range bounds {
+		h.Buckets[i].Min = lo
+		if i+1 < len(bounds) {
+			h.Buckets[i].Max = bounds[i+1] - 1
+		}
+	}
+	for _, n := range ref {
+		i := len(bounds) - 1
+		for bounds[i] > n {
+			i--
+		}
+		h.Buckets[i].IPs++
+		h.Buckets[i].Occurrences += n
+		if n == 1 {
+			h.Singletons++
+		} else {
+			h.Repeaters++
+		}
+	}
+	return h
+}
+
+func TestHistogram_Buckets(t *testing.T) {
+	lines, ref := heavyCorpus(131)
+	path := writeTempFile(t, "hist.txt", lines)
+	for _, opts := range []ipcount.Options{
+		{Readers: 3, Shards: 7},
+		{Readers: 3, Shards: 1 << 16, Layout: ipcount.LayoutRange},
+	} {
+		freeBitsets()
+		c, err := ipcount.New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		freq, _, err := c.Frequencies(context.Background(), []string{path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, bounds := range [][]uint64{nil, {1}, {1, 2}, {1, 3, 256, 301, 70000, 70001}} {
+			want := refHistogram(ref, ipcount.DefaultBuckets)
+			if bounds != nil {
+				want = refHistogram(ref, bounds)
+			}
+			got, err := freq.Histogram(bounds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got.Buckets, want.Buckets) || got.Singletons != want.Singletons || got.Repeaters != want.Repeaters {
+				t.Fatalf("%+v bounds %v:\n got %+v\nwant %+v", opts, bounds, got, want)
+			}
+		}
+	}
+}
+
+func TestHistogram_BoundsAndOutput(t *testing.T) {
+	for _, s := range []string{"", "0,1", "2,3", "1,3,2", "1,1", "1,x"} {
+		if _, err := ipcount.ParseBuckets(s); err == nil {
+			t.Fatalf("ParseBuckets(%q) accepted", s)
+		}
+	}
+	bounds, err := ipcount.ParseBuckets("1, 2,11")
+	if err != nil || !slices.Equal(bounds, []uint64{1, 2, 11}) {
+		t.Fatalf("ParseBuckets = %v, %v", bounds, err)
+	}
+
+	path := writeTempFile(t, "few.txt", []string{"1.1.1.1\n", "2.2.2.2\n", "1.1.1.1\n", "3.3.3.3\n"})
+	c, err := ipcount.New(ipcount.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	freq, res, err := c.Frequencies(context.Background(), []string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := freq.Histogram(bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := freq.Histogram([]uint64{2}); err == nil {
+		t.Fatal("Histogram accepted bounds from 2")
+	}
+
+	var human bytes.Buffer
+	if err := writeHistogram(&human, h, res.Unique, res.Lines.Valid, "human"); err != nil {
+		t.Fatal(err)
+	}
+	want := "Seen 1 time: 2 IPs (66.7%), 2 lines (50.0%).\n" +
+		"Seen 2-10 times: 1 IPs (33.3%), 2 lines (50.0%).\n" +
+		"Seen 11+ times: 0 IPs (0.0%), 0 lines (0.0%).\n" +
+		"Singletons: 2 (66.7%), repeaters: 1 (33.3%).\n"
+	if human.String() != want {
+		t.Fatalf("human output:\n%s\nwant:\n%s", human.String(), want)
+	}
+
+	var machine bytes.Buffer
+	if err := writeHistogram(&machine, h, res.Unique, res.Lines.Valid, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Unique, Lines uint64
+		ipcount.Histogram
+	}
+	if err := json.NewDecoder(strings.NewReader(machine.String())).Decode(&got); err != nil {
+		t.Fatalf("json output %q: %v", machine.String(), err)
+	}
+	if got.Unique != 3 || got.Lines != 4 || !slices.Equal(got.Buckets, h.Buckets) || got.Singletons != 2 || got.Repeaters != 1 {
+		t.Fatalf("json output decodes to %+v", got)
+	}
+}
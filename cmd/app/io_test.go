@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"strings"
+	"testing"
+)
+
+// countIO counts path with every reader mode in modes and checks they all agree with want.
+func countIO(t *testing.T, path string, want uint64, cfg read.Config, modes ...read.IOMode) {
+	t.Helper()
+	for _, mode := range modes {
+		cfg.IO = mode
+		got, _, err := read.UniqueIPv4CountFiles([]string{path}, cfg)
+		if err != nil {
+			t.Fatalf("io=%s R=%d: %v", mode, cfg.Readers, err)
+		}
+		if got != want {
+			t.Fatalf("io=%s R=%d: count=%d, want %d", mode, cfg.Readers, got, want)
+		}
+	}
+}
+
+func TestIOModes_MatchPread(t *testing.T) {
+	lines := randomLines(31, 150000)
+	lines[len(lines)-1] = "9.9.9.9" // no trailing '\n'
+	path := writeTempFile(t, "modes.txt", lines)
+	want := uint64(refUniqueIPv4Count(t, path))
+
+	modes := []read.IOMode{read.IOPread, read.IOMmap, read.IOURing}
+	if directSupported(t, path) {
+		modes = append(modes, read.IODirect)
+	}
+	for _, R := range []int{1, 3, 8} {
+		countIO(t, path, want, read.Config{Shards: 8, Readers: R, BufMB: 1, ProbeKB: 1}, modes...)
+	}
+	countIO(t, writeTempFile(t, "empty.txt", nil), 0, read.Config{Shards: 2, Readers: 2, BufMB: 1, ProbeKB: 1}, read.IOMmap)
+}
+
+// directSupported reports whether O_DIRECT reads work for files next to path.
+func directSupported(t *testing.T, path string) bool {
+	t.Helper()
+	_, _, err := read.UniqueIPv4CountFiles([]string{path}, read.Config{Shards: 1, Readers: 1, BufMB: 1, IO: read.IODirect})
+	if errors.Is(err, read.ErrDirectUnsupported) {
+		t.Logf("skipping io=direct: %v", err)
+		return false
+	}
+	if err != nil {
+		t.Fatalf("io=direct: %v", err)
+	}
+	return true
+}
+
+func TestIODirect_UnalignedSizes(t *testing.T) {
+	// sizes around the 4 KiB alignment grid, several readers so segment starts are unaligned too
+	for _, n := range []int{300, 455, 456, 457, 4096, 70001} {
+		freeBitsets()
+		lines := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			lines = append(lines, ipToString(10, i>>16&255, i>>8&255, i&255, i%5 == 0)+"\n")
+		}
+		lines[n-1] = strings.TrimSuffix(lines[n-1], "\n")
+		path := writeTempFile(t, "direct.txt", lines)
+		if !directSupported(t, path) {
+			return
+		}
+		want := uint64(refUniqueIPv4Count(t, path))
+		for _, R := range []int{1, 5} {
+			countIO(t, path, want, read.Config{Shards: 4, Readers: R, BufMB: 1, ProbeKB: 1}, read.IODirect)
+		}
+	}
+}
+
+func TestParseIOMode(t *testing.T) {
+	for _, mode := range []read.IOMode{read.IOPread, read.IOMmap, read.IODirect, read.IOURing} {
+		got, err := read.ParseIOMode(mode.String())
+		if err != nil || got != mode {
+			t.Fatalf("ParseIOMode(%q) = %v, %v", mode.String(), got, err)
+		}
+	}
+	if _, err := read.ParseIOMode("nope"); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+}
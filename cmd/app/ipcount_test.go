@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIPCount_RejectsBadOptions(t *testing.T) {
+	for _, tc := range []struct {
+		field string
+		opts  ipcount.Options
+	}{
+		{"Shards", ipcount.Options{Shards: -1}},
+		{"Shards", ipcount.Options{Shards: ipcount.MaxShards + 1}},
+		{"Readers", ipcount.Options{Readers: -4}},
+		{"BufMB", ipcount.Options{BufMB: -1}},
+		{"BufMB", ipcount.Options{BufMB: ipcount.MaxBufMB + 1}},
+		{"ProbeKB", ipcount.Options{ProbeKB: -1}},
+		{"RetryAttempts", ipcount.Options{RetryAttempts: -1}},
+		{"RetryBackoff", ipcount.Options{RetryBackoff: -time.Second}},
+		{"IO", ipcount.Options{IO: ipcount.IOMode(42)}},
+	} {
+		c, err := ipcount.New(tc.opts)
+		var oe *ipcount.OptionError
+		if c != nil || !errors.As(err, &oe) || oe.Field != tc.field {
+			t.Fatalf("%+v: counter=%v err=%v, want *OptionError for %s", tc.opts, c, err, tc.field)
+		}
+	}
+}
+
+func TestIPCount_ZeroOptionsCountCorrectly(t *testing.T) {
+	// zero BufMB and ProbeKB used to mean empty reads and unaligned segments
+	lines := randomLines(71, 100000)
+	path := writeTempFile(t, "zero.txt", lines)
+	want := uint64(refUniqueIPv4Count(t, path))
+
+	for _, opts := range []ipcount.Options{{}, {Readers: 7}, {Readers: 7, Shards: 3, IO: ipcount.IOMmap}} {
+		c, err := ipcount.New(opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		res, err := c.CountFile(context.Background(), path)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if res.Unique != want {
+			t.Fatalf("%+v: unique=%d, want %d", opts, res.Unique, want)
+		}
+		if res.Lines.Lines != uint64(len(lines)) {
+			t.Fatalf("%+v: lines=%d, want %d", opts, res.Lines.Lines, len(lines))
+		}
+	}
+}
+
+func TestIPCount_EntryPoints(t *testing.T) {
+	lines := randomLines(73, 50000)
+	data := strings.Join(lines, "")
+	path := writeTempFile(t, "entry.txt", lines)
+	want := uint64(refUniqueIPv4Count(t, path))
+	ctx := context.Background()
+
+	c, err := ipcount.New(ipcount.Options{Readers: 4, BufMB: 1, PerFile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.CountReader(ctx, bytes.NewReader(gzipMembers(t, []byte(data))))
+	if err != nil || res.Unique != want {
+		t.Fatalf("reader: unique=%d err=%v, want %d", res.Unique, err, want)
+	}
+	if res.Lines.Invalid == 0 {
+		t.Fatalf("reader: randomLines junk not accounted: %+v", res.Lines)
+	}
+
+	res, err = c.CountFiles(ctx, []string{path, path})
+	if err != nil || res.Unique != want || len(res.Files) != 2 {
+		t.Fatalf("files: %+v err=%v, want %d", res, err, want)
+	}
+	if res.Files[0].Added != want || res.Files[1].Unique != want || res.Files[1].Added != 0 {
+		t.Fatalf("files: per-file %+v", res.Files)
+	}
+
+	if _, err := c.CountFiles(ctx, nil); !errors.Is(err, ipcount.ErrNoInput) {
+		t.Fatalf("no paths: err=%v, want ErrNoInput", err)
+	}
+	if _, err := c.CountFile(ctx, filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing file: err=%v", err)
+	}
+
+	strict, err := ipcount.New(ipcount.Options{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = strict.CountFile(ctx, path)
+	var le *ipcount.LineError
+	if !errors.As(err, &le) || le.Line != "a.b.c.d" {
+		t.Fatalf("strict: err=%v, want *LineError for a.b.c.d", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.CountFile(cancelled, path); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled: err=%v", err)
+	}
+}
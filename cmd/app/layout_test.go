@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// skewedLines builds n lines of which about 90% fall into a few /8s, like real traffic logs.
+func skewedLines(seed int64, n int) []string {
+	r := rand.New(rand.NewSource(seed))
+	hot := []uint32{10, 100, 192}
+	lines := make([]string, n)
+	for i := range lines {
+		ip := r.Uint32()
+		if r.Intn(10) != 0 {
+			ip = hot[r.Intn(len(hot))]<<24 | ip&0xFFFFF // a /12 of a hot /8: plenty of repeats
+		}
+		lines[i] = ipcount.Addr(ip).String() + "\n"
+	}
+	return lines
+}
+
+func TestLayout_RangeMatchesModulo(t *testing.T) {
+	lines := append(skewedLines(101, 150000), randomLines(103, 20000)...)
+	data := strings.Join(lines, "")
+	path := writeBytes(t, "skewed.txt", []byte(data))
+	gz := writeBytes(t, "skewed.gz", gzipMembers(t, []byte(data), len(data)/3, 2*len(data)/3))
+	want := refUnique(data)
+
+	for _, shards := range []int{1, 3, 64, 1000} {
+		for _, mode := range []read.IOMode{read.IOPread, read.IOMmap} {
+			freeBitsets()
+			cfg := read.Config{Shards: shards, Readers: 3, BufMB: 1, IO: mode, Layout: read.LayoutRange, PerFile: true}
+			got, files, err := read.UniqueIPv4CountFiles([]string{path, gz}, cfg)
+			if err != nil {
+				t.Fatalf("shards=%d io=%s: %v", shards, mode, err)
+			}
+			if got != want || files[0].Unique != want || files[1].Unique != want || files[1].Added != 0 {
+				t.Fatalf("shards=%d io=%s: unique=%d files=%+v, want %d", shards, mode, got, files, want)
+			}
+		}
+		cfg := read.Config{Shards: shards, Readers: 2, BufMB: 1, Layout: read.LayoutRange}
+		got, err := read.UniqueIPv4CountReaderContext(context.Background(), strings.NewReader(data), cfg)
+		if err != nil || got != want {
+			t.Fatalf("shards=%d stream: unique=%d err=%v, want %d", shards, got, err, want)
+		}
+	}
+}
+
+func TestLayout_ExportAndCollect(t *testing.T) {
+	path := writeTempFile(t, "skewed.txt", skewedLines(107, 200000))
+	ctx := context.Background()
+	var exports [][]byte
+	var sets []*ipcount.Set
+	for _, opts := range []ipcount.Options{
+		{Shards: 7},
+		{Shards: 7, Layout: ipcount.LayoutRange}, // 8 shards of a /3 each
+		{Shards: 1 << 16, Layout: ipcount.LayoutRange},
+	} {
+		c, err := ipcount.New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if _, err := c.ExportFiles(ctx, []string{path}, &out, ipcount.ExportRaw); err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		exports = append(exports, out.Bytes())
+		var s ipcount.Set
+		if _, err := c.CollectFile(ctx, path, &s); err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		sets = append(sets, &s)
+	}
+	for i := 1; i < len(exports); i++ {
+		if !bytes.Equal(exports[i], exports[0]) {
+			t.Fatalf("layout %d exports %d bytes, modulo %d", i, len(exports[i]), len(exports[0]))
+		}
+		if d := sets[i].Difference(sets[0]).Len() + sets[0].Difference(sets[i]).Len(); d != 0 {
+			t.Fatalf("layout %d collects a set %d addresses off", i, d)
+		}
+	}
+}
+
+func TestLayout_Parse(t *testing.T) {
+	for _, l := range []ipcount.ShardLayout{ipcount.LayoutModulo, ipcount.LayoutRange} {
+		if got, err := ipcount.ParseShardLayout(l.String()); err != nil || got != l {
+			t.Fatalf("ParseShardLayout(%q) = %v, %v", l, got, err)
+		}
+	}
+	if _, err := ipcount.ParseShardLayout("hash"); err == nil {
+		t.Fatal("ParseShardLayout accepted hash")
+	}
+	if _, err := ipcount.New(ipcount.Options{Layout: 9}); err == nil || !strings.Contains(err.Error(), "Layout") {
+		t.Fatalf("New with layout 9: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+// dirtyLines is randomLines with malformed and blank lines mixed in.
+func dirtyLines(seed int64, n int) []string {
+	r := rand.New(rand.NewSource(seed))
+	junk := []string{"a.b.c.d\n", "1.2.3\n", "256.1.1.1\n", " 1.1.1.1\n", "1.1.1.1.\n", "\n", "\r\n", "1..2.3\r\n", "10.0.0.1x\n"}
+	var out []string
+	for _, l := range randomLines(seed, n) {
+		if r.Intn(50) == 0 {
+			out = append(out, junk[r.Intn(len(junk))])
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// refLineStats is the line accounting of data done the slow way.
+func refLineStats(data string, path string) read.LineStats {
+	var st read.LineStats
+	off := 0
+	for off < len(data) {
+		end := strings.IndexByte(data[off:], '\n')
+		if end < 0 {
+			end = len(data) - off
+		}
+		line := strings.TrimSuffix(data[off:off+end], "\r")
+		st.Lines++
+		switch _, ok := refParseIPv4(line); {
+		case ok:
+			st.Valid++
+		case line == "":
+			st.Blank++
+		default:
+			st.Invalid++
+			if len(line) > 15 {
+				st.Long++
+			}
+			st.Samples = append(st.Samples, read.InvalidLine{Path: path, Offset: int64(off), Line: line[:min(len(line), 64)]})
+		}
+		off += end + 1
+	}
+	return st
+}
+
+// checkLineStats compares got with want, whose samples are cut to the first n.
+func checkLineStats(t *testing.T, name string, got, want read.LineStats, n int) {
+	t.Helper()
+	if got.Lines != want.Lines || got.Valid != want.Valid || got.Invalid != want.Invalid || got.Blank != want.Blank || got.Long != want.Long {
+		t.Fatalf("%s: lines/valid/invalid/blank/long = %d/%d/%d/%d/%d, want %d/%d/%d/%d/%d", name,
+			got.Lines, got.Valid, got.Invalid, got.Blank, got.Long, want.Lines, want.Valid, want.Invalid, want.Blank, want.Long)
+	}
+	want.Samples = want.Samples[:min(n, len(want.Samples))]
+	if len(got.Samples) != len(want.Samples) {
+		t.Fatalf("%s: %d samples, want %d", name, len(got.Samples), len(want.Samples))
+	}
+	for i, w := range want.Samples {
+		g := got.Samples[i]
+		// a long line not held whole is reported by its head
+		same := g == w || len(w.Line) > 15 && len(g.Line) > 15 && g.Path == w.Path && g.Offset == w.Offset && strings.HasPrefix(w.Line, g.Line)
+		if !same {
+			t.Fatalf("%s: sample %d = %+v, want %+v", name, i, g, w)
+		}
+	}
+}
+
+func TestLines_AccountingEveryMode(t *testing.T) {
+	lines := dirtyLines(51, 150000)
+	lines = append(lines, "bad tail") // no trailing '\n'
+	data := strings.Join(lines, "")
+	path := writeTempFile(t, "dirty.txt", lines)
+	want := refLineStats(data, path)
+	if want.Invalid < 100 || want.Blank == 0 {
+		t.Fatalf("corpus too clean: %+v", want)
+	}
+
+	modes := []read.IOMode{read.IOPread, read.IOMmap, read.IOURing}
+	if directSupported(t, path) {
+		modes = append(modes, read.IODirect)
+	}
+	for _, mode := range modes {
+		for _, R := range []int{1, 5} {
+			var st read.LineStats
+			cfg := read.Config{Shards: 8, Readers: R, BufMB: 1, ProbeKB: 1, IO: mode, Samples: 25, Stats: &st}
+			if _, _, err := read.UniqueIPv4CountFiles([]string{path}, cfg); err != nil {
+				t.Fatalf("io=%s R=%d: %v", mode, R, err)
+			}
+			checkLineStats(t, mode.String(), st, want, 25)
+		}
+	}
+
+	// compressed ranges and streams report offsets in the decoded data
+	gz := writeBytes(t, "dirty.gz", gzipMembers(t, []byte(data), len(data)/4, len(data)/2, 3*len(data)/4))
+	var st read.LineStats
+	if _, _, err := read.UniqueIPv4CountFiles([]string{gz}, read.Config{Shards: 8, Readers: 4, BufMB: 1, Stats: &st}); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	wantGz := refLineStats(data, gz)
+	checkLineStats(t, "gzip", st, wantGz, 10)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	go func() {
+		_, _ = w.WriteString(data)
+		_ = w.Close()
+	}()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	if _, _, err := read.UniqueIPv4CountFiles([]string{read.Stdin}, read.Config{Shards: 8, Readers: 3, BufMB: 1, Stats: &st}); err != nil {
+		t.Fatalf("stdin: %v", err)
+	}
+	checkLineStats(t, "stdin", st, refLineStats(data, read.Stdin), 10)
+}
+
+func TestLines_SamplesAcrossFiles(t *testing.T) {
+	a := writeTempFile(t, "a.txt", []string{"1.1.1.1\n", "x\n", "2.2.2.2\n", "y\n"})
+	b := writeTempFile(t, "b.txt", []string{"p\n", "q\n", "3.3.3.3\n", "r\n"})
+	var st read.LineStats
+	if _, _, err := read.UniqueIPv4CountFiles([]string{a, b}, read.Config{Readers: 2, BufMB: 1, ProbeKB: 1, Samples: 3, Stats: &st}); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	want := []read.InvalidLine{{Path: a, Offset: 8, Line: "x"}, {Path: a, Offset: 18, Line: "y"}, {Path: b, Offset: 0, Line: "p"}}
+	if st.Lines != 8 || st.Invalid != 5 || len(st.Samples) != len(want) {
+		t.Fatalf("stats %+v", st)
+	}
+	for i := range want {
+		if st.Samples[i] != want[i] {
+			t.Fatalf("sample %d = %+v, want %+v", i, st.Samples[i], want[i])
+		}
+	}
+
+	if _, _, err := read.UniqueIPv4CountFiles([]string{a}, read.Config{BufMB: 1, ProbeKB: 1, Samples: -1, Stats: &st}); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if st.Invalid != 2 || len(st.Samples) != 0 {
+		t.Fatalf("no samples wanted: %+v", st)
+	}
+}
+
+func TestLines_Strict(t *testing.T) {
+	lines := randomLines(53, 100000)
+	lines = lines[:len(lines)-4] // drop the junk randomLines ends with
+	clean := strings.Join(lines, "")
+	at := len(clean) / 3
+	at += strings.IndexByte(clean[at:], '\n') + 1
+	data := clean[:at] + "10.0.0.300\n" + clean[at:]
+
+	path := writeBytes(t, "strict.txt", []byte(data))
+	gz := writeBytes(t, "strict.gz", gzipMembers(t, []byte(data), len(data)/4, len(data)/2, 3*len(data)/4))
+	for _, tc := range []struct {
+		path string
+		cfg  read.Config
+	}{
+		{path, read.Config{Shards: 8, Readers: 1, BufMB: 1, Strict: true}},
+		{path, read.Config{Shards: 8, Readers: 6, BufMB: 1, ProbeKB: 1, Strict: true, IO: read.IOMmap}},
+		{gz, read.Config{Shards: 8, Readers: 4, BufMB: 1, Strict: true}},
+	} {
+		_, _, err := read.UniqueIPv4CountFiles([]string{tc.path}, tc.cfg)
+		var le *read.LineError
+		if !errors.As(err, &le) {
+			t.Fatalf("%s R=%d: err=%v, want *LineError", tc.path, tc.cfg.Readers, err)
+		}
+		if le.Path != tc.path || le.Offset != int64(at) || le.Line != "10.0.0.300" {
+			t.Fatalf("%s R=%d: %+v, want offset %d", tc.path, tc.cfg.Readers, le.InvalidLine, at)
+		}
+	}
+
+	ok := writeBytes(t, "clean.txt", []byte(clean+"\n\r\n"))
+	if _, _, err := read.UniqueIPv4CountFiles([]string{ok}, read.Config{Readers: 4, BufMB: 1, ProbeKB: 1, Strict: true}); err != nil {
+		t.Fatalf("clean file with blank lines: %v", err)
+	}
+}
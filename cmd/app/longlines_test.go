@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// ipOfLen formats a random IPv4 zero-padded to exactly n (7..15) characters.
+func ipOfLen(r *rand.Rand, n int) string {
+	w := [4]int{1, 1, 1, 1}
+	for extra := n - 7; extra > 0; {
+		if i := r.Intn(4); w[i] < 3 {
+			w[i]++
+			extra--
+		}
+	}
+	var parts [4]string
+	for i, wi := range w {
+		lim := [...]int{0, 10, 100, 256}[wi]
+		parts[i] = fmt.Sprintf("%0*d", wi, r.Intn(lim))
+	}
+	return strings.Join(parts[:], ".")
+}
+
+// ipLikeLine is a line of n bytes every fragment of which looks like IPv4 text.
+func ipLikeLine(n int) string {
+	return strings.Repeat("1.2.3.4.", n/8+1)[:n]
+}
+
+// straddleCorpus builds exactly size bytes in which a line of each length in lengths crosses each of
+// the given offsets (cycling through the lengths, at varying split points); the rest is valid IPv4 lines.
+func straddleCorpus(seed int64, size int, at []int, lengths []int) string {
+	r := rand.New(rand.NewSource(seed))
+	var b strings.Builder
+	fill := func(to int) {
+		for g := to - b.Len(); g > 0; g = to - b.Len() {
+			switch {
+			case g > 24:
+				b.WriteString(ipOfLen(r, 7+r.Intn(9)) + "\n")
+			case g >= 8 && g <= 16:
+				b.WriteString(ipOfLen(r, g-1) + "\n")
+			case g > 16:
+				b.WriteString(ipOfLen(r, 7) + "\n")
+			default:
+				b.WriteString("\n")
+			}
+		}
+	}
+	sort.Ints(at)
+	for i, off := range at {
+		n := lengths[i%len(lengths)]
+		start := off - (i*7919)%(n+1) // the offset falls anywhere from the line start to its '\n'
+		if start < b.Len() || start+n+1 > size {
+			continue
+		}
+		fill(start)
+		b.WriteString(ipLikeLine(n) + "\n")
+	}
+	fill(size)
+	return b.String()
+}
+
+// refUnique counts the distinct IPv4s of data with the reference parser.
+func refUnique(data string) uint64 {
+	seen := make(map[uint32]struct{})
+	for _, line := range strings.Split(data, "\n") {
+		if ip, ok := refParseIPv4(strings.TrimSuffix(line, "\r")); ok {
+			seen[ip] = struct{}{}
+		}
+	}
+	return uint64(len(seen))
+}
+
+func TestLongLines_AcrossBufferAndSegmentBoundaries(t *testing.T) {
+	const mib = 1 << 20
+	size := 12*mib + 12345
+	var at []int
+	for b := mib; b < size; b += mib {
+		at = append(at, b) // buffer boundaries of a 1 MiB block reader
+	}
+	for _, R := range []int{3, 5, 7} {
+		for i := 1; i < R; i++ {
+			at = append(at, size*i/R) // segment split points
+		}
+	}
+	var lengths []int
+	for n := 0; n <= 40; n++ {
+		lengths = append(lengths, n)
+	}
+	lengths = append(lengths, 63, 64, 65, 100, 1000, 1025, 4096, 70000, 3*mib/2)
+	// every length crosses a buffer boundary somewhere: repeat the offsets until all lengths are used
+	for len(at) < 2*len(lengths) {
+		at = append(at, at...)
+	}
+	for i := range at {
+		at[i] += (i / 40) * 977 // shift repeated offsets off each other, still crossing near the boundary
+	}
+	data := straddleCorpus(61, size, at, lengths)
+	path := writeBytes(t, "straddle.txt", []byte(data))
+	want := refLineStats(data, path)
+	wantUnique := refUnique(data)
+	if want.Long < 40 {
+		t.Fatalf("corpus has only %d long lines", want.Long)
+	}
+
+	modes := []read.IOMode{read.IOPread, read.IOMmap, read.IOURing}
+	if directSupported(t, path) {
+		modes = append(modes, read.IODirect)
+	}
+	for _, mode := range modes {
+		for _, R := range []int{1, 3, 7} {
+			var st read.LineStats
+			cfg := read.Config{Shards: 8, Readers: R, BufMB: 1, ProbeKB: 1, IO: mode, Samples: 1000, Stats: &st}
+			got, _, err := read.UniqueIPv4CountFiles([]string{path}, cfg)
+			if err != nil {
+				t.Fatalf("io=%s R=%d: %v", mode, R, err)
+			}
+			name := fmt.Sprintf("io=%s R=%d", mode, R)
+			if got != wantUnique {
+				t.Fatalf("%s: unique=%d, want %d", name, got, wantUnique)
+			}
+			checkLineStats(t, name, st, want, 1000)
+		}
+	}
+
+	gz := writeBytes(t, "straddle.gz", gzipMembers(t, []byte(data), size/5, size/3, size/2+7, 4*size/5))
+	for _, R := range []int{1, 4} {
+		var st read.LineStats
+		got, _, err := read.UniqueIPv4CountFiles([]string{gz}, read.Config{Shards: 8, Readers: R, BufMB: 1, Samples: 1000, Stats: &st})
+		if err != nil {
+			t.Fatalf("gzip R=%d: %v", R, err)
+		}
+		if got != wantUnique {
+			t.Fatalf("gzip R=%d: unique=%d, want %d", R, got, wantUnique)
+		}
+		checkLineStats(t, fmt.Sprintf("gzip R=%d", R), st, refLineStats(data, gz), 1000)
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	go func() {
+		_, _ = pw.WriteString(data)
+		_ = pw.Close()
+	}()
+	stdin := os.Stdin
+	os.Stdin = pr
+	defer func() { os.Stdin = stdin }()
+	var st read.LineStats
+	got, _, err := read.UniqueIPv4CountFiles([]string{read.Stdin}, read.Config{Shards: 8, Readers: 3, BufMB: 1, Samples: 1000, Stats: &st})
+	if err != nil {
+		t.Fatalf("stdin: %v", err)
+	}
+	if got != wantUnique {
+		t.Fatalf("stdin: unique=%d, want %d", got, wantUnique)
+	}
+	checkLineStats(t, "stdin", st, refLineStats(data, read.Stdin), 1000)
+}
+
+func TestLongLines_Strict(t *testing.T) {
+	const mib = 1 << 20
+	data := straddleCorpus(67, 3*mib, []int{mib}, []int{70000})
+	path := writeBytes(t, "long.txt", []byte(data))
+	want := refLineStats(data, path)
+	if want.Long != 1 {
+		t.Fatalf("corpus has %d long lines", want.Long)
+	}
+	for _, mode := range []read.IOMode{read.IOPread, read.IOMmap} {
+		_, _, err := read.UniqueIPv4CountFiles([]string{path}, read.Config{Readers: 1, BufMB: 1, IO: mode, Strict: true})
+		var le *read.LineError
+		if !errors.As(err, &le) || le.Offset != want.Samples[0].Offset || !strings.HasPrefix(want.Samples[0].Line, le.Line) {
+			t.Fatalf("io=%s: err=%v, want a *LineError at offset %d", mode, err, want.Samples[0].Offset)
+		}
+	}
+}
@@ -1,35 +1,348 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/Borislavv/ip-file-counter/internal/read"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 )
 
-var (
-	flagShards  = flag.Int("shards", 256, "number of shards (default: min(GOMAXPROCS*4,64))")
-	flagReaders = flag.Int("readers", 48, "number of parallel readers (default: min(GOMAXPROCS,8))")
-	flagBufMB   = flag.Int("bufMB", 32, "per-reader block size in Mb")
-	flagProbeKB = flag.Int("probeKB", 4, "segment align probe window in Kb")
-)
-
 func main() {
+	// "filter" writes the input lines back deduplicated instead of counting them,
+	// "merge" takes .ipset snapshots instead of text inputs, "compare" two inputs' sets, "overlap" many
+	args, cmd := os.Args[1:], ""
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "filter":
+		filterMain(args[1:])
+	case "merge":
+		mergeMain(args[1:])
+	case "compare":
+		compareMain(args[1:])
+	case "overlap":
+		overlapMain(args[1:])
+	default:
+		countMain(args)
+	}
+}
+
+// countMain counts the unique IPs of its inputs, exporting them, carrying them over between snapshots
+// or counting how often each was seen if asked to.
+func countMain(args []string) {
 	var from = time.Now()
 
-	flag.Parse()
-	if flag.NArg() < 1 {
-		_, _ = fmt.Fprintf(os.Stderr, "usage: %s <path-to-file>\n", filepath.Base(os.Args[0]))
+	fs := newFlagSet("", "[flags] <path | glob | dir | ->...", "-load <snapshot> [-save out.ipset] [flags] [<path | glob | dir | ->...]", "filter [flags] <path | glob | dir | ->...",
+		"merge [flags] <snapshot.ipset>...", "compare [flags] <A> <B>", "overlap [flags] <path | glob | dir>...")
+	var in inputFlags
+	in.register(fs)
+	var (
+		flagPerFile   = fs.Bool("per-file", false, "print per-file unique and first-seen counts (doubles bitset memory)")
+		flagExport    = fs.String("export", "", "write the unique IPs in ascending order to this file (- for stdout)")
+		flagExportFmt = fs.String("export-format", "text", "export encoding: text | raw | varint | roaring (a portable Roaring bitmap)")
+		flagOnly      = fs.String("only", "", "export only the IPs seen more than once or exactly once: duplicates | singletons (to -export, stdout by default; 1 GiB of bitsets)")
+		flagLoad      = fs.String("load", "", "start from the IPs of this .ipset snapshot or Roaring bitmap and report how many were newly seen")
+		flagSave      = fs.String("save", "", "save the final set of IPs to this .ipset snapshot (may be the -load one)")
+		flagSaveComp  = fs.String("save-compression", "none", "snapshot compression of -save: none | gzip")
+		flagTop       = fs.Int("top", 0, "print the K most frequent IPs with their exact counts (0: off)")
+		flagCounters  = fs.String("counters", "auto", "per-IP counters of -top and -histogram: auto | sparse | 8 | 16")
+		flagHistogram = fs.String("histogram", "", "print how many IPs were seen how many times: human | json (JSON on stdout, report on stderr)")
+		flagBuckets   = fs.String("buckets", "1,2,3,5,11,101,1001,10001", "histogram bucket lower bounds, ascending from 1")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 && *flagLoad == "" { // -load alone re-saves or converts a snapshot
+		fs.Usage()
 		os.Exit(2)
 	}
-	path := flag.Arg(0)
+	opts := in.options()
+	exportFmt, err := ipcount.ParseExportFormat(*flagExportFmt)
+	if err != nil {
+		fail(err)
+	}
+	pick := ipcount.PickAll
+	if *flagOnly != "" {
+		if pick, err = ipcount.ParsePick(*flagOnly); err != nil {
+			fail(err)
+		}
+		if *flagExport == "" {
+			*flagExport = "-"
+		}
+	}
+	saveComp, err := ipcount.ParseSnapshotCompression(*flagSaveComp)
+	if err != nil {
+		fail(err)
+	}
+	if opts.Counters, err = ipcount.ParseCounters(*flagCounters); err != nil {
+		fail(err)
+	}
+	buckets, err := ipcount.ParseBuckets(*flagBuckets)
+	if err != nil {
+		fail(err)
+	}
+	switch *flagHistogram {
+	case "", "human", "json":
+	default:
+		fail(fmt.Errorf("unknown histogram format %q", *flagHistogram))
+	}
+	freqMode := *flagTop > 0 || *flagHistogram != ""
+	if *flagTop < 0 || freqMode && *flagExport != "" {
+		fail(errors.New("-top takes a positive K; -top and -histogram don't combine with -export or -only"))
+	}
+	snapMode := *flagLoad != "" || *flagSave != ""
+	if snapMode && (freqMode || *flagExport != "") {
+		fail(errors.New("-load and -save don't combine with -export, -only, -top or -histogram"))
+	}
+	opts.PerFile = *flagPerFile
+	paths := in.inputs(fs.Args())
+	ctx := signalContext()
+	counter, stopProgress := in.start(opts)
 
-	total, err := read.UniqueIPv4Count(path, *flagShards, *flagReaders, *flagBufMB, *flagProbeKB)
+	var res ipcount.Result
+	var top []ipcount.IPCount
+	var hist ipcount.Histogram
+	report := os.Stdout
+	switch {
+	case freqMode:
+		var freq *ipcount.Frequencies
+		if freq, res, err = counter.Frequencies(ctx, paths); err == nil {
+			top = freq.Top(*flagTop)
+			hist, err = freq.Histogram(buckets)
+		}
+		if *flagHistogram == "json" {
+			report = os.Stderr // stdout carries the JSON
+		}
+	case snapMode:
+		res, err = countSnapshot(ctx, counter, paths, *flagLoad, *flagSave, saveComp)
+	case *flagExport == "":
+		res, err = counter.CountFiles(ctx, paths)
+	case *flagExport == "-":
+		report = os.Stderr // stdout carries the export
+		res, err = counter.ExportPick(ctx, paths, os.Stdout, exportFmt, pick)
+	default:
+		res, err = exportToFile(ctx, counter, paths, *flagExport, exportFmt, pick)
+	}
+	stopProgress()
 	if err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, "ERR:", err)
-		os.Exit(2)
+		fail(err)
+	}
+	for _, fc := range res.Files {
+		_, _ = fmt.Fprintf(report, "File: %s, unique: %d, added: %d.\n", fc.Path, fc.Unique, fc.Added)
+	}
+	for i, ic := range top {
+		_, _ = fmt.Fprintf(report, "Top %d: %s, count: %d.\n", i+1, ipcount.Addr(ic.IP), ic.Count)
+	}
+	st := res.Lines
+	if *flagHistogram != "" {
+		if err := writeHistogram(os.Stdout, hist, res.Unique, st.Valid, *flagHistogram); err != nil {
+			fail(err)
+		}
+	}
+	printSamples(st.Samples)
+	if *flagLoad != "" {
+		_, _ = fmt.Fprintf(report, "Loaded: %d, newly seen: %d.\n", res.Loaded, res.Unique-res.Loaded)
+	}
+	if pick != ipcount.PickAll {
+		_, _ = fmt.Fprintf(report, "Exported %s: %d.\n", pick, res.Exported)
+	}
+	printLines(report, "Lines", st)
+	_, _ = fmt.Fprintf(report, "Unique IPv4 Count: %d, elapsed: %s.\n", res.Unique, time.Since(from).String())
+}
+
+// inputFlags are the flags of every subcommand reading text inputs: how they're expanded, read and reported on.
+type inputFlags struct {
+	shards, readers, bufMB, probeKB int
+	recursive                       bool
+	io, layout                      string
+	retries                         int
+	backoff                         time.Duration
+	progress                        bool
+	every                           time.Duration
+	strict                          bool
+	samples                         int
+}
+
+func (f *inputFlags) register(fs *flag.FlagSet) {
+	fs.IntVar(&f.shards, "shards", 256, "number of shards (default: min(GOMAXPROCS*4,64))")
+	fs.IntVar(&f.readers, "readers", 48, "number of parallel readers (default: min(GOMAXPROCS,8))")
+	fs.IntVar(&f.bufMB, "bufMB", 32, "per-reader block size in Mb")
+	fs.IntVar(&f.probeKB, "probeKB", 4, "segment align probe window in Kb")
+	fs.BoolVar(&f.recursive, "r", false, "walk directories recursively")
+	fs.StringVar(&f.io, "io", "pread", "plain-text file reader: pread | mmap | direct | uring")
+	fs.StringVar(&f.layout, "layout", "modulo", "shard layout: modulo (ip%shards) | range (ip>>k, shards rounded up to a power of two)")
+	fs.IntVar(&f.retries, "retries", 0, "retries of reads failing with EINTR/EAGAIN")
+	fs.DurationVar(&f.backoff, "retry-backoff", 10*time.Millisecond, "pause before the first retry, doubled on each next one")
+	fs.BoolVar(&f.progress, "progress", false, "print progress to stderr even when it's not a terminal")
+	fs.DurationVar(&f.every, "progress-every", time.Second, "progress report interval")
+	fs.BoolVar(&f.strict, "strict", false, "fail on the first malformed line, reporting its file and byte offset")
+	fs.IntVar(&f.samples, "samples", 10, "invalid lines to print as samples (0: none)")
+}
+
+// options turns the flags into the Options of a Counter.
+func (f *inputFlags) options() ipcount.Options {
+	ioMode, err := ipcount.ParseIOMode(f.io)
+	if err != nil {
+		fail(err)
+	}
+	layout, err := ipcount.ParseShardLayout(f.layout)
+	if err != nil {
+		fail(err)
+	}
+	opts := ipcount.Options{
+		Shards:        f.shards,
+		Readers:       f.readers,
+		BufMB:         f.bufMB,
+		ProbeKB:       f.probeKB,
+		IO:            ioMode,
+		Layout:        layout,
+		RetryAttempts: f.retries,
+		RetryBackoff:  f.backoff,
+		Strict:        f.strict,
+		Samples:       f.samples,
+	}
+	if opts.Samples == 0 {
+		opts.Samples = -1 // Options' zero means the default
+	}
+	return opts
+}
+
+// inputs expands args into the files to read.
+func (f *inputFlags) inputs(args []string) []string {
+	paths, err := ipcount.ExpandInputs(args, f.recursive)
+	if err != nil {
+		fail(err)
+	}
+	return paths
+}
+
+// start builds the Counter of opts and, on a terminal or with -progress, reports its progress to stderr
+// until the returned func is called.
+func (f *inputFlags) start(opts ipcount.Options) (*ipcount.Counter, func()) {
+	tty := isTerminal(os.Stderr)
+	if tty || f.progress {
+		opts.Progress = &ipcount.Progress{}
+	}
+	counter, err := ipcount.New(opts)
+	if err != nil {
+		fail(err)
+	}
+	if opts.Progress == nil {
+		return counter, func() {}
+	}
+	quit := make(chan struct{})
+	done := reportProgress(os.Stderr, opts.Progress, f.every, tty, quit)
+	return counter, func() { close(quit); <-done }
+}
+
+// newFlagSet returns the flag set of a subcommand ("" for the plain count) whose usage
+// lists the given forms of the command line, then the flags.
+func newFlagSet(cmd string, forms ...string) *flag.FlagSet {
+	name := filepath.Base(os.Args[0])
+	fs := flag.NewFlagSet(name+" "+cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		for i, form := range forms {
+			lead := "usage:"
+			if i > 0 {
+				lead = "      "
+			}
+			_, _ = fmt.Fprintf(fs.Output(), "%s %s %s\n", lead, name, form)
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// signalContext is done on Ctrl-C, which stops the run cleanly; a second one kills the process as usual.
+func signalContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx
+}
+
+// fail reports err on stderr and exits with status 2.
+func fail(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, "ERR:", err)
+	os.Exit(2)
+}
+
+// printSamples prints the sampled invalid lines to stderr.
+func printSamples(samples []ipcount.InvalidLine) {
+	for _, bad := range samples {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid line: %s at offset %d: %q\n", bad.Path, bad.Offset, bad.Line)
+	}
+}
+
+// printLines prints the line accounting of a run under the given label.
+func printLines(w io.Writer, label string, st ipcount.LineStats) {
+	_, _ = fmt.Fprintf(w, "%s: %d, valid: %d, invalid: %d (too long: %d), blank: %d.\n", label, st.Lines, st.Valid, st.Invalid, st.Long, st.Blank)
+}
+
+// exportToFile runs an export into path, removing the file again if the run fails.
+func exportToFile(ctx context.Context, counter *ipcount.Counter, paths []string, path string, format ipcount.ExportFormat, pick ipcount.Pick) (ipcount.Result, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return ipcount.Result{}, err
+	}
+	res, err := counter.ExportPick(ctx, paths, f, format, pick)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("export: %w", cerr)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return res, err
+}
+
+// countSnapshot runs a count starting from the snapshot at load and saving to the one at save, either optional.
+func countSnapshot(ctx context.Context, counter *ipcount.Counter, paths []string, load, save string, comp ipcount.SnapshotCompression) (ipcount.Result, error) {
+	snap := ipcount.Snapshot{Compression: comp}
+	if load != "" {
+		f, err := os.Open(load)
+		if err != nil {
+			return ipcount.Result{}, fmt.Errorf("load: %w", err)
+		}
+		defer f.Close()
+		snap.Load = f
+	}
+	if save == "" {
+		return counter.CountSnapshot(ctx, paths, snap)
+	}
+	var res ipcount.Result
+	err := writeAtomically(save, func(w io.Writer) error {
+		snap.Save = w
+		var err error
+		res, err = counter.CountSnapshot(ctx, paths, snap)
+		return err
+	})
+	return res, err
+}
+
+// writeAtomically runs write into a temporary file next to path and renames it over path once complete,
+// so path may be one of the run's own inputs; the temporary file is removed if anything fails.
+func writeAtomically(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("save: %w", cerr)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
 	}
-	fmt.Printf("Unique IPv4 Count: %d, elapsed: %s.\n", total, time.Since(from).String())
+	return err
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"github.com/Borislavv/ip-file-counter/internal/codec"
 	"github.com/Borislavv/ip-file-counter/internal/read"
+	"io"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -36,6 +40,32 @@ func runCountBench(b *testing.B, readers, shards int) {
 	}
 }
 
+func runIOBench(b *testing.B, mode read.IOMode) {
+	path := benchFile(b)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cfg := read.Config{
+		Shards:  min(runtime.GOMAXPROCS(0)*4, 64),
+		Readers: min(runtime.GOMAXPROCS(0), 8),
+		BufMB:   32,
+		ProbeKB: 1,
+		IO:      mode,
+	}
+
+	b.SetBytes(fi.Size())
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := read.UniqueIPv4CountFiles([]string{path}, cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkCount_Serial(b *testing.B) {
 	runCountBench(b, 1, 1)
 }
@@ -49,3 +79,65 @@ func BenchmarkCount_Throughput(b *testing.B) {
 	S := min(runtime.GOMAXPROCS(0)*4, 64)
 	runCountBench(b, R, S)
 }
+
+// Page-cache-hot comparison of the reader modes (same shards/readers as Throughput).
+func BenchmarkIO_Pread(b *testing.B) {
+	runIOBench(b, read.IOPread)
+}
+
+func BenchmarkIO_Mmap(b *testing.B) {
+	runIOBench(b, read.IOMmap)
+}
+
+func BenchmarkIO_URing(b *testing.B) {
+	runIOBench(b, read.IOURing)
+}
+
+// runLayoutBench counts a generated corpus (skewed into a few /8s, or uniform) with the given layout,
+// then walks the bitsets in address order the way -export does.
+func runLayoutBench(b *testing.B, layout read.ShardLayout, skewed bool) {
+	lines := skewedLines(111, 1<<20)
+	if !skewed {
+		lines = randomLines(111, 1<<20)
+	}
+	data := []byte(strings.Join(lines, ""))
+	path := filepath.Join(b.TempDir(), "corpus.txt")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	cfg := read.Config{
+		Shards:  min(runtime.GOMAXPROCS(0)*4, 64),
+		Readers: min(runtime.GOMAXPROCS(0), 8),
+		BufMB:   4,
+		Layout:  layout,
+		Export:  &read.Export{W: io.Discard, Format: codec.ListRaw},
+	}
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := read.UniqueIPv4CountFiles([]string{path}, cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Shard layouts on traffic concentrated in three /8s (where range shards get uneven load but
+// neighbouring addresses share cache lines) and on uniform traffic.
+func BenchmarkLayout_SkewedModulo(b *testing.B) {
+	runLayoutBench(b, read.LayoutModulo, true)
+}
+
+func BenchmarkLayout_SkewedRange(b *testing.B) {
+	runLayoutBench(b, read.LayoutRange, true)
+}
+
+func BenchmarkLayout_UniformModulo(b *testing.B) {
+	runLayoutBench(b, read.LayoutModulo, false)
+}
+
+func BenchmarkLayout_UniformRange(b *testing.B) {
+	runLayoutBench(b, read.LayoutRange, false)
+}
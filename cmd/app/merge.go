@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+)
+
+// mergeMain prints the cardinality of the union of .ipset snapshots, saving the union if asked to.
+func mergeMain(args []string) {
+	var from = time.Now()
+
+	fs := newFlagSet("merge", "merge [flags] <snapshot.ipset | glob | dir>...")
+	var (
+		flagRecursive = fs.Bool("r", false, "walk directories recursively")
+		flagSave      = fs.String("save", "", "save the union to this .ipset snapshot (may be one of the inputs)")
+		flagSaveComp  = fs.String("save-compression", "none", "snapshot compression of -save: none | gzip")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	saveComp, err := ipcount.ParseSnapshotCompression(*flagSaveComp)
+	if err != nil {
+		fail(err)
+	}
+	paths, err := ipcount.ExpandInputs(fs.Args(), *flagRecursive)
+	if err != nil {
+		fail(err)
+	}
+
+	n, err := mergeSnapshots(signalContext(), paths, *flagSave, saveComp)
+	if err != nil {
+		fail(err)
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "Merged: %d snapshots.\n", len(paths))
+	_, _ = fmt.Fprintf(os.Stdout, "Unique IPv4 Count: %d, elapsed: %s.\n", n, time.Since(from).String())
+}
+
+// mergeSnapshots merges the snapshots at paths, into the one at save if given, and returns the union cardinality.
+func mergeSnapshots(ctx context.Context, paths []string, save string, comp ipcount.SnapshotCompression) (uint64, error) {
+	if save == "" {
+		return ipcount.MergeSnapshots(ctx, paths, nil, comp)
+	}
+	var n uint64
+	err := writeAtomically(save, func(w io.Writer) error {
+		var err error
+		n, err = ipcount.MergeSnapshots(ctx, paths, w, comp)
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Borislavv/ip-file-counter/internal/snapshot"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// snapshotOf writes ips (any order, repeats allowed) as a snapshot without counting them.
+func snapshotOf(t *testing.T, ips []uint32, c ipcount.SnapshotCompression) []byte {
+	t.Helper()
+	sorted := slices.Clone(ips)
+	slices.Sort(sorted)
+	var out bytes.Buffer
+	sw, err := snapshot.NewWriter(&out, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := make([]uint64, snapshot.WindowWords)
+	for i := 0; i < len(sorted); {
+		lo := sorted[i] &^ (1<<snapshot.WindowBits - 1)
+		clear(w)
+		for ; i < len(sorted) && sorted[i]&^(1<<snapshot.WindowBits-1) == lo; i++ {
+			off := sorted[i] - lo
+			w[off>>6] |= 1 << (off & 63)
+		}
+		if err := sw.Window(lo, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestMerge_UnionOfManySnapshots(t *testing.T) {
+	r := rand.New(rand.NewSource(161))
+	union := make(map[uint32]bool)
+	var paths []string
+	for i := range 13 { // more inputs than cores: groups hold several
+		var ips []uint32
+		switch i {
+		case 0: // empty
+		case 1: // a dense window
+			for lo := range 1 << 16 {
+				ips = append(ips, 0x0A0B0000|uint32(lo))
+			}
+		default:
+			ips = append(randomIPs(r, 20000), 0, 0xFFFFFFFF, 0x0A0B0001)
+		}
+		for _, ip := range ips {
+			union[ip] = true
+		}
+		paths = append(paths, writeBytes(t, "host.ipset", snapshotOf(t, ips, ipcount.SnapshotCompression(i%2))))
+	}
+	want := make([]uint32, 0, len(union))
+	for ip := range union {
+		want = append(want, ip)
+	}
+	slices.Sort(want)
+
+	for _, n := range []int{1, 2, len(paths)} {
+		got, err := ipcount.MergeSnapshots(context.Background(), paths[:n], nil, ipcount.SnapshotNone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == len(paths) && got != uint64(len(want)) {
+			t.Fatalf("union of %d: %d, want %d", n, got, len(want))
+		}
+	}
+	for _, comp := range []ipcount.SnapshotCompression{ipcount.SnapshotNone, ipcount.SnapshotGzip} {
+		var out bytes.Buffer
+		n, err := ipcount.MergeSnapshots(context.Background(), paths, &out, comp)
+		if err != nil || n != uint64(len(want)) {
+			t.Fatalf("%s: %d, %v", comp, n, err)
+		}
+		if got := readSnapshot(t, out.Bytes()); !slices.Equal(got, want) {
+			t.Fatalf("%s: merged snapshot holds %d addresses, want %d", comp, len(got), len(want))
+		}
+	}
+}
+
+func TestMerge_Errors(t *testing.T) {
+	r := rand.New(rand.NewSource(163))
+	good := snapshotOf(t, randomIPs(r, 5000), ipcount.SnapshotGzip)
+	plain := snapshotOf(t, randomIPs(r, 5000), ipcount.SnapshotNone)
+	bad := bytes.Clone(plain)
+	bad[len(bad)-1] ^= 1
+	paths := []string{writeBytes(t, "good.ipset", good), writeBytes(t, "bad.ipset", bad)}
+
+	if _, err := ipcount.MergeSnapshots(context.Background(), nil, nil, ipcount.SnapshotNone); err != ipcount.ErrNoInput {
+		t.Fatalf("no paths: %v", err)
+	}
+	var out bytes.Buffer
+	_, err := ipcount.MergeSnapshots(context.Background(), paths, &out, ipcount.SnapshotNone)
+	if !errors.Is(err, ipcount.ErrSnapshotChecksum) || !strings.Contains(err.Error(), paths[1]) {
+		t.Fatalf("bad checksum: %v", err)
+	}
+	text := writeTempFile(t, "ips.txt", []string{"1.1.1.1\n"})
+	if _, err := ipcount.MergeSnapshots(context.Background(), []string{paths[0], text}, nil, ipcount.SnapshotNone); !errors.Is(err, ipcount.ErrSnapshotFormat) || !strings.Contains(err.Error(), text) {
+		t.Fatalf("text input: %v", err)
+	}
+	truncated := writeBytes(t, "cut.ipset", plain[:len(plain)/2])
+	if _, err := ipcount.MergeSnapshots(context.Background(), []string{paths[0], truncated}, nil, ipcount.SnapshotNone); err == nil || !strings.Contains(err.Error(), truncated) {
+		t.Fatalf("truncated input: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ipcount.MergeSnapshots(ctx, paths[:1], nil, ipcount.SnapshotNone); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled: %v", err)
+	}
+}
+
+func TestMerge_MoreInputsThanMaxOpen(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp) // where the union is folded through
+	r := rand.New(rand.NewSource(167))
+	union := make(map[uint32]bool)
+	var paths []string
+	for i := range 2*snapshot.MaxOpen + 3 {
+		ips := randomIPs(r, 200)
+		for _, ip := range ips {
+			union[ip] = true
+		}
+		paths = append(paths, writeBytes(t, "host.ipset", snapshotOf(t, ips, ipcount.SnapshotCompression(i%2))))
+	}
+	want := make([]uint32, 0, len(union))
+	for ip := range union {
+		want = append(want, ip)
+	}
+	slices.Sort(want)
+
+	var out bytes.Buffer
+	n, err := ipcount.MergeSnapshots(context.Background(), paths, &out, ipcount.SnapshotNone)
+	if err != nil || n != uint64(len(want)) {
+		t.Fatalf("%d inputs: %d, %v; want %d", len(paths), n, err, len(want))
+	}
+	if got := readSnapshot(t, out.Bytes()); !slices.Equal(got, want) {
+		t.Fatalf("merged snapshot holds %d addresses, want %d", len(got), len(want))
+	}
+
+	// a bad input past the first batches is still named
+	last := len(paths) - 2
+	paths[last] = writeTempFile(t, "ips.txt", []string{"1.1.1.1\n"})
+	if _, err := ipcount.MergeSnapshots(context.Background(), paths, nil, ipcount.SnapshotNone); !errors.Is(err, ipcount.ErrSnapshotFormat) || !strings.Contains(err.Error(), paths[last]) {
+		t.Fatalf("text input: %v", err)
+	}
+	if left, err := os.ReadDir(tmp); err != nil || len(left) != 0 {
+		t.Fatalf("temporary snapshots left behind: %v, %v", left, err)
+	}
+}
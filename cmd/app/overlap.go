@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+)
+
+// overlapMain writes the pairwise overlap matrix of its inputs to stdout and reports on stderr.
+func overlapMain(args []string) {
+	var from = time.Now()
+
+	fs := newFlagSet("overlap", "overlap [flags] <path | glob | dir>...")
+	var in inputFlags
+	in.register(fs)
+	var (
+		flagMatrix   = fs.String("matrix", "csv", "overlap matrix format: csv | json")
+		flagBudgetMB = fs.Uint64("budget-mb", 4096, "bitset memory in MiB: 512 per resident file, less than the files need takes several passes")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *flagMatrix != "csv" && *flagMatrix != "json" {
+		fail(fmt.Errorf("unknown matrix format %q", *flagMatrix))
+	}
+	opts := in.options()
+	paths := in.inputs(fs.Args())
+	ctx := signalContext()
+	counter, stopProgress := in.start(opts)
+
+	ov, err := counter.Overlap(ctx, paths, *flagBudgetMB<<20)
+	stopProgress()
+	if err == nil {
+		err = writeOverlap(os.Stdout, ov, *flagMatrix)
+	}
+	if err != nil {
+		fail(err)
+	}
+	var st ipcount.LineStats
+	for _, f := range ov.Files {
+		printSamples(f.Lines.Samples)
+		st.Lines, st.Valid, st.Invalid, st.Long, st.Blank = st.Lines+f.Lines.Lines, st.Valid+f.Lines.Valid, st.Invalid+f.Lines.Invalid, st.Long+f.Lines.Long, st.Blank+f.Lines.Blank
+	}
+	printLines(os.Stderr, "Lines", st)
+	_, _ = fmt.Fprintf(os.Stderr, "Overlap: %d files, passes: %d, elapsed: %s.\n", len(ov.Files), ov.Passes, time.Since(from).String())
+}
+
+// writeOverlap prints ov either as JSON (format "json") or as CSV: a header row "file,unique,exclusive"
+// followed by the paths, then one row per file with its counts and its row of the matrix.
+func writeOverlap(w io.Writer, ov *ipcount.Overlap, format string) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(ov)
+	}
+	cw := csv.NewWriter(w)
+	header := []string{"file", "unique", "exclusive"}
+	for _, f := range ov.Files {
+		header = append(header, f.Path)
+	}
+	_ = cw.Write(header)
+	for i, f := range ov.Files {
+		row := []string{f.Path, strconv.FormatUint(f.Unique, 10), strconv.FormatUint(f.Exclusive, 10)}
+		for _, n := range ov.Matrix[i] {
+			row = append(row, strconv.FormatUint(n, 10))
+		}
+		_ = cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
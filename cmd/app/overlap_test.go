@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestOverlap_MatrixWithinAnyBudget(t *testing.T) {
+	r := rand.New(rand.NewSource(191))
+	shared := randomIPs(r, 5000)
+	var paths []string
+	var sets []*ipcount.Set
+	for i := range 5 {
+		var ips []uint32
+		switch i {
+		case 2: // empty
+		case 4: // file 0 but its shared part, and one more: nothing of file 0 is exclusive
+			ips = append(randomIPs(rand.New(rand.NewSource(192)), 8000), 0xFFFFFFFF)
+		default:
+			ips = randomIPs(rand.New(rand.NewSource(192+int64(i))), 8000)
+			ips = append(ips, shared[:1000*(i+1)]...)
+		}
+		s, _ := setOf(ips)
+		var lines []string
+		for _, ip := range ips {
+			lines = append(lines, ipcount.Addr(ip).String()+"\n")
+		}
+		paths = append(paths, writeTempFile(t, "day.txt", append(lines, "junk\n")))
+		sets = append(sets, s)
+	}
+
+	c, err := ipcount.New(ipcount.Options{Readers: 2, Shards: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		budget uint64
+		passes int
+	}{
+		{0, 1},                         // the default fits all five
+		{4 * ipcount.BitsetBytes, 3},   // two resident at a time, the last pass with one
+		{3*ipcount.BitsetBytes + 1, 5}, // one at a time
+	} {
+		freeBitsets()
+		ov, err := c.Overlap(context.Background(), paths, tc.budget)
+		if err != nil {
+			t.Fatalf("budget %d: %v", tc.budget, err)
+		}
+		if ov.Passes != tc.passes {
+			t.Fatalf("budget %d: %d passes, want %d", tc.budget, ov.Passes, tc.passes)
+		}
+		for i, f := range ov.Files {
+			others := ipcount.NewSet()
+			for j := range sets {
+				if want := sets[i].Intersect(sets[j]).Len(); ov.Matrix[i][j] != want {
+					t.Fatalf("budget %d: |F%d ∩ F%d| = %d, want %d", tc.budget, i, j, ov.Matrix[i][j], want)
+				}
+				if j != i {
+					others.Merge(sets[j])
+				}
+			}
+			if f.Path != paths[i] || f.Unique != sets[i].Len() || f.Exclusive != sets[i].Difference(others).Len() {
+				t.Fatalf("budget %d: file %d %+v, want unique %d, exclusive %d", tc.budget, i, f, sets[i].Len(), sets[i].Difference(others).Len())
+			}
+			if f.Lines.Invalid != 1 || f.Lines.Valid != f.Lines.Lines-1 {
+				t.Fatalf("budget %d: file %d lines %+v", tc.budget, i, f.Lines)
+			}
+		}
+	}
+
+	ov, err := c.Overlap(context.Background(), paths[:2], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := writeOverlap(&out, ov, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil || len(rows) != 3 || rows[0][3] != paths[0] || rows[2][0] != paths[1] {
+		t.Fatalf("csv: %q, %v", rows, err)
+	}
+	if rows[1][2] != strconv.FormatUint(ov.Files[0].Exclusive, 10) || rows[2][3] != strconv.FormatUint(ov.Matrix[1][0], 10) {
+		t.Fatalf("csv rows: %q", rows)
+	}
+	out.Reset()
+	if err := writeOverlap(&out, ov, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var back ipcount.Overlap
+	if err := json.Unmarshal(out.Bytes(), &back); err != nil || back.Matrix[0][1] != ov.Matrix[0][1] || back.Files[1].Exclusive != ov.Files[1].Exclusive {
+		t.Fatalf("json: %s, %v", out.String(), err)
+	}
+}
+
+func TestOverlap_Errors(t *testing.T) {
+	a := writeTempFile(t, "a.txt", []string{"1.1.1.1\n", "bad\n"})
+	b := writeTempFile(t, "b.txt", []string{"2.2.2.2\n"})
+	c, err := ipcount.New(ipcount.Options{Readers: 2, Shards: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Overlap(context.Background(), nil, 0); err != ipcount.ErrNoInput {
+		t.Fatalf("no paths: %v", err)
+	}
+	if _, err := c.Overlap(context.Background(), []string{a, "-"}, 0); err != ipcount.ErrOverlapStdin {
+		t.Fatalf("stdin: %v", err)
+	}
+	if _, err := c.Overlap(context.Background(), []string{a, b, a}, 2*ipcount.BitsetBytes); err == nil {
+		t.Fatal("a budget of two bitsets for three files accepted")
+	}
+	if ov, err := c.Overlap(context.Background(), []string{a, b}, 2*ipcount.BitsetBytes); err != nil || ov.Matrix[0][1] != 0 {
+		t.Fatalf("two files in two bitsets: %v", err)
+	}
+	strict, err := ipcount.New(ipcount.Options{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var le *ipcount.LineError
+	if _, err := strict.Overlap(context.Background(), []string{b, a}, 0); !errors.As(err, &le) || le.Path != a {
+		t.Fatalf("strict: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Overlap(ctx, []string{a, b}, 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExportPick_DuplicatesAndSingletons(t *testing.T) {
+	lines, ref := heavyCorpus(131)
+	var dups, singles []uint32
+	for ip, n := range ref {
+		if n > 1 {
+			dups = append(dups, ip)
+		} else {
+			singles = append(singles, ip)
+		}
+	}
+	slices.Sort(dups)
+	slices.Sort(singles)
+	// a repeat split over two files must count as one too
+	half := len(lines) / 2
+	paths := []string{writeTempFile(t, "a.txt", append(lines[:half:half], "junk\n")), writeTempFile(t, "b.txt", lines[half:])}
+
+	for _, opts := range []ipcount.Options{
+		{Readers: 3, Shards: 7},
+		{Readers: 2, Shards: 64, Layout: ipcount.LayoutRange},
+		{Readers: 2, Shards: 5, PerFile: true},
+	} {
+		freeBitsets()
+		c, err := ipcount.New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tc := range []struct {
+			pick ipcount.Pick
+			want []uint32
+		}{{ipcount.PickDuplicates, dups}, {ipcount.PickSingletons, singles}} {
+			var out bytes.Buffer
+			res, err := c.ExportPick(context.Background(), paths, &out, ipcount.ExportText, tc.pick)
+			if err != nil {
+				t.Fatalf("%+v %s: %v", opts, tc.pick, err)
+			}
+			if res.Unique != uint64(len(ref)) || res.Exported != uint64(len(tc.want)) {
+				t.Fatalf("%+v %s: unique=%d exported=%d, want %d and %d", opts, tc.pick, res.Unique, res.Exported, len(ref), len(tc.want))
+			}
+			if got := decodeExport(t, out.Bytes(), ipcount.ExportText); !slices.Equal(got, tc.want) {
+				t.Fatalf("%+v %s: %d addresses exported, want %d", opts, tc.pick, len(got), len(tc.want))
+			}
+			if opts.PerFile && len(res.Files) != 2 {
+				t.Fatalf("%+v %s: files %+v", opts, tc.pick, res.Files)
+			}
+		}
+	}
+}
+
+func TestExportPick_EdgeCases(t *testing.T) {
+	c, err := ipcount.New(ipcount.Options{Shards: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(133))
+	var lines []string
+	for _, ip := range randomIPs(r, 1000) {
+		lines = append(lines, ipcount.Addr(ip).String()+"\n")
+	}
+	path := writeTempFile(t, "once.txt", lines)
+	var out bytes.Buffer
+	res, err := c.ExportPick(context.Background(), []string{path}, &out, ipcount.ExportVarint, ipcount.PickDuplicates)
+	if err != nil || res.Exported != 0 || out.Len() != 0 || res.Unique == 0 {
+		t.Fatalf("no repeats: %+v, %d bytes, %v", res, out.Len(), err)
+	}
+	res, err = c.ExportPick(context.Background(), []string{path}, &out, ipcount.ExportRaw, ipcount.PickSingletons)
+	if err != nil || res.Exported != res.Unique || out.Len() != 4*int(res.Unique) {
+		t.Fatalf("all singletons: %+v, %d bytes, %v", res, out.Len(), err)
+	}
+	if res, err := c.ExportFiles(context.Background(), []string{path}, &out, ipcount.ExportText); err != nil || res.Exported != res.Unique {
+		t.Fatalf("ExportFiles: %+v, %v", res, err)
+	}
+	if _, err := c.ExportPick(context.Background(), []string{path}, &out, ipcount.ExportText, 7); err == nil {
+		t.Fatal("pick 7 accepted")
+	}
+	for _, name := range []string{"all", "duplicates", "singletons"} {
+		if got, err := ipcount.ParsePick(name); err != nil || got.String() != name {
+			t.Fatalf("ParsePick(%q) = %v, %v", name, got, err)
+		}
+	}
+	if _, err := ipcount.ParsePick("dups"); err == nil || !strings.Contains(err.Error(), "dups") {
+		t.Fatalf("ParsePick(dups): %v", err)
+	}
+}
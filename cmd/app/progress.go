@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+)
+
+// isTerminal reports whether f is a character device, i.e. most likely an interactive terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// reportProgress prints a progress line to w every interval until stop is closed, then a final one.
+// On a terminal the line is redrawn in place; otherwise every report goes on its own line.
+// The returned channel is closed once the last line is written.
+func reportProgress(w io.Writer, p *ipcount.Progress, interval time.Duration, tty bool, stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				writeProgress(w, p.Snapshot(), tty)
+			case <-stop:
+				writeProgress(w, p.Snapshot(), tty)
+				if tty {
+					_, _ = fmt.Fprintln(w)
+				}
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func writeProgress(w io.Writer, s ipcount.ProgressSnapshot, tty bool) {
+	line := humanBytes(float64(s.Bytes))
+	if pct, ok := s.Percent(); ok {
+		line += fmt.Sprintf(" / %s (%.1f%%)", humanBytes(float64(s.Total)), pct)
+	}
+	line += fmt.Sprintf(", %s/s, %d lines", humanBytes(s.Rate), s.Lines)
+	if eta, ok := s.ETA(); ok {
+		line += ", ETA " + eta.Round(time.Second).String()
+	}
+	if tty {
+		_, _ = fmt.Fprintf(w, "\r\033[K%s", line)
+		return
+	}
+	_, _ = fmt.Fprintln(w, line)
+}
+
+// humanBytes formats n with a binary unit suffix.
+func humanBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	i := -1
+	for n >= 1024 && i+1 < len(units) {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", n, units[i])
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkProgress counts paths with a Progress attached and checks it ends up at size bytes and lines lines.
+func checkProgress(t *testing.T, name string, paths []string, cfg read.Config, size, lines int64) read.ProgressSnapshot {
+	t.Helper()
+	cfg.Progress = &read.Progress{}
+	if _, _, err := read.UniqueIPv4CountFiles(paths, cfg); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	s := cfg.Progress.Snapshot()
+	if s.Bytes != size || s.Lines != lines {
+		t.Fatalf("%s: bytes=%d lines=%d, want %d and %d", name, s.Bytes, s.Lines, size, lines)
+	}
+	var segs int64
+	for _, n := range s.Segments {
+		segs += n
+	}
+	if len(paths) == 1 && segs != size {
+		t.Fatalf("%s: segments sum to %d, want %d", name, segs, size)
+	}
+	return s
+}
+
+func TestProgress_CountsBytesAndLines(t *testing.T) {
+	lines := randomLines(41, 120000)
+	lines[len(lines)-1] = "9.9.9.9" // no trailing '\n'
+	data := strings.Join(lines, "")
+	path := writeTempFile(t, "progress.txt", lines)
+	size, n := int64(len(data)), int64(len(lines))
+
+	modes := []read.IOMode{read.IOPread, read.IOMmap, read.IOURing}
+	if directSupported(t, path) {
+		modes = append(modes, read.IODirect)
+	}
+	for _, mode := range modes {
+		cfg := read.Config{Shards: 8, Readers: 4, BufMB: 1, ProbeKB: 1, IO: mode}
+		s := checkProgress(t, mode.String(), []string{path}, cfg, size, n)
+		if pct, ok := s.Percent(); !ok || pct != 100 {
+			t.Fatalf("%s: percent=%v,%v, want 100", mode, pct, ok)
+		}
+		if len(s.Segments) != 4 {
+			t.Fatalf("%s: %d segments, want 4", mode, len(s.Segments))
+		}
+	}
+
+	// compressed inputs are metered in compressed bytes, against the compressed size
+	gz := gzipMembers(t, []byte(data), len(data)/3, 2*len(data)/3)
+	gzPath := writeBytes(t, "progress.gz", gz)
+	cfg := read.Config{Shards: 8, Readers: 3, BufMB: 1}
+	checkProgress(t, "gzip", []string{gzPath}, cfg, int64(len(gz)), n)
+
+	// many files add up
+	s := checkProgress(t, "files", []string{path, gzPath}, cfg, size+int64(len(gz)), 2*n)
+	if s.Total != size+int64(len(gz)) {
+		t.Fatalf("files: total=%d, want %d", s.Total, size+int64(len(gz)))
+	}
+}
+
+func TestProgress_StreamHasNoTotal(t *testing.T) {
+	lines := randomLines(43, 20000)
+	data := []byte(strings.Join(lines, ""))
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	go func() {
+		_, _ = w.Write(data)
+		_ = w.Close()
+	}()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	s := checkProgress(t, "stdin", []string{read.Stdin}, read.Config{Shards: 4, Readers: 2, BufMB: 1}, int64(len(data)), int64(len(lines)))
+	if _, ok := s.Percent(); ok {
+		t.Fatalf("stdin: percent known without a total")
+	}
+	if _, ok := s.ETA(); ok {
+		t.Fatalf("stdin: ETA known without a total")
+	}
+}
+
+func TestProgress_ETA(t *testing.T) {
+	s := read.ProgressSnapshot{Bytes: 25, Total: 100, Elapsed: 10 * time.Second}
+	if pct, _ := s.Percent(); pct != 25 {
+		t.Fatalf("percent=%v, want 25", pct)
+	}
+	if eta, ok := s.ETA(); !ok || eta != 30*time.Second {
+		t.Fatalf("eta=%v,%v, want 30s", eta, ok)
+	}
+}
+
+func TestProgress_ReportLine(t *testing.T) {
+	var buf bytes.Buffer
+	s := read.ProgressSnapshot{Bytes: 3 << 30, Total: 12 << 30, Lines: 42, Elapsed: time.Minute, Rate: 1.5 * (1 << 30)}
+	writeProgress(&buf, s, false)
+	want := "3.0 GiB / 12.0 GiB (25.0%), 1.5 GiB/s, 42 lines, ETA 3m0s\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	p := &read.Progress{}
+	stop := make(chan struct{})
+	done := reportProgress(&buf, p, time.Hour, false, stop)
+	close(stop)
+	<-done
+	if got := buf.String(); got != "0 B, 0 B/s, 0 lines\n" {
+		t.Fatalf("final line %q", got)
+	}
+}
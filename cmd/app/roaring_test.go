@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"io"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRoaring_RoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(171))
+	sets := map[string][]uint32{
+		"empty":  nil,
+		"sparse": randomIPs(r, 20000),
+		"edges":  {0, 1, 0xFFFF, 0x10000, 0xFFFFFFFE, 0xFFFFFFFF},
+	}
+	var dense, ranges []uint32
+	for lo := range 1 << 16 { // a full /16 is one run
+		dense = append(dense, 0x0A0B0000|uint32(lo))
+	}
+	for i := range 9000 { // more than an array holds, scattered: a bitmap
+		dense = append(dense, 0x0C000000|uint32(r.Intn(1<<16)))
+		if i%3 == 0 {
+			dense = append(dense, 0x0D000000|uint32(i*7))
+		}
+	}
+	sets["dense"] = dense
+	for i := range 40 { // runs spanning container boundaries
+		lo := uint32(r.Int63n(1<<32 - 1<<17))
+		for ip := lo; ip < lo+uint32(r.Intn(1<<17)); ip++ {
+			ranges = append(ranges, ip)
+		}
+		if i%2 == 0 {
+			ranges = append(ranges, randomIPs(r, 100)...)
+		}
+	}
+	sets["ranges"] = ranges
+	sets["mixed"] = append(append(slices.Clone(dense), ranges...), sets["sparse"]...)
+
+	for name, ips := range sets {
+		s := ipcount.NewSet()
+		for _, ip := range ips {
+			s.Add(ip)
+		}
+		var buf bytes.Buffer
+		n, err := s.WriteRoaring(&buf)
+		if err != nil || n != int64(buf.Len()) {
+			t.Fatalf("%s: wrote %d (%d bytes), %v", name, n, buf.Len(), err)
+		}
+		buf.WriteString("tail") // ReadRoaring stops at the end of the bitmap
+		got, err := ipcount.ReadRoaring(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.Len() != s.Len() || !slices.Equal(slices.Collect(got.All()), slices.Collect(s.All())) {
+			t.Fatalf("%s: read back %d addresses, want %d", name, got.Len(), s.Len())
+		}
+		if buf.String() != "tail" {
+			t.Fatalf("%s: read past the end, %q left", name, buf.String())
+		}
+	}
+}
+
+// TestRoaring_SpecVectors checks the bytes against the layout of the format spec, as the Roaring libraries
+// serialize these sets.
+func TestRoaring_SpecVectors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ips  []uint32
+		hex  string
+	}{
+		// cookie 12346, 1 container, key 0 card-1 0, offset 16, the array {0}
+		{"array", []uint32{0}, "3a300000" + "01000000" + "00000000" + "10000000" + "0000"},
+		// cookie 12347 with 1 container, run bitset 1, key 0 card-1 99, no offsets, 1 run of 1+99
+		{"run", seq(1, 100), "3b300000" + "01" + "00006300" + "0100" + "01006300"},
+		// two containers, the second holding 0x20000 and 0x20002
+		{"keys", []uint32{7, 0x20000, 0x20002}, "3a300000" + "02000000" + "00000000" + "02000100" +
+			"18000000" + "1a000000" + "0700" + "00000200"},
+	} {
+		s := ipcount.NewSet()
+		for _, ip := range tc.ips {
+			s.Add(ip)
+		}
+		var buf bytes.Buffer
+		if _, err := s.WriteRoaring(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(buf.Bytes()); got != tc.hex {
+			t.Fatalf("%s: wrote %s, want %s", tc.name, got, tc.hex)
+		}
+		data, _ := hex.DecodeString(tc.hex)
+		got, err := ipcount.ReadRoaring(bytes.NewReader(data))
+		if err != nil || !slices.Equal(slices.Collect(got.All()), tc.ips) {
+			t.Fatalf("%s: read %v, %v", tc.name, err, tc.ips)
+		}
+	}
+	// a run container with offsets (4 containers or more) and a bitmap container, as other writers lay them out
+	s := ipcount.NewSet()
+	for i := range 4 {
+		for lo := range uint32(5000) {
+			s.Add(uint32(i)<<16 | lo*(1+uint32(i%2)*12)%(1<<16))
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := s.WriteRoaring(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ipcount.ReadRoaring(&buf); err != nil || got.Len() != s.Len() {
+		t.Fatalf("runs and bitmaps with offsets: %v", err)
+	}
+}
+
+func seq(lo, hi uint32) []uint32 {
+	var out []uint32
+	for ip := lo; ip <= hi; ip++ {
+		out = append(out, ip)
+	}
+	return out
+}
+
+func TestRoaring_RejectsMalformed(t *testing.T) {
+	for name, h := range map[string]string{
+		"cookie":          "3c300000",
+		"keys out":        "3a300000" + "02000000" + "02000000" + "01000000" + "18000000" + "1a000000" + "0700" + "0700",
+		"array order":     "3a300000" + "01000000" + "00000100" + "10000000" + "07000300",
+		"card mismatch":   "3b300000" + "01" + "00006400" + "0100" + "01006300",
+		"runs overlap":    "3b300000" + "01" + "00000900" + "0200" + "00000400" + "03000400",
+		"run past 0xFFFF": "3b300000" + "01" + "00000100" + "0100" + "ffff0100",
+	} {
+		data, _ := hex.DecodeString(h)
+		if _, err := ipcount.ReadRoaring(bytes.NewReader(data)); !errors.Is(err, ipcount.ErrRoaring) {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	s := ipcount.NewSet()
+	for _, ip := range randomIPs(rand.New(rand.NewSource(173)), 3000) {
+		s.Add(ip)
+	}
+	var buf bytes.Buffer
+	if _, err := s.WriteRoaring(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, cut := range []int{0, 3, 7, buf.Len() / 2, buf.Len() - 1} {
+		if _, err := ipcount.ReadRoaring(bytes.NewReader(buf.Bytes()[:cut])); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("cut at %d: %v", cut, err)
+		}
+	}
+}
+
+func TestRoaring_ExportAndLoad(t *testing.T) {
+	lines, ref := heavyCorpus(175)
+	path := writeTempFile(t, "ips.txt", lines)
+	c, err := ipcount.New(ipcount.Options{Readers: 3, Shards: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	res, err := c.ExportFiles(context.Background(), []string{path}, &out, ipcount.ExportRoaring)
+	if err != nil || res.Exported != uint64(len(ref)) {
+		t.Fatalf("export: %+v, %v", res, err)
+	}
+	set, err := ipcount.ReadRoaring(bytes.NewReader(out.Bytes()))
+	if err != nil || set.Len() != uint64(len(ref)) {
+		t.Fatalf("read export: %v", err)
+	}
+	for ip := range ref {
+		if !set.Contains(ip) {
+			t.Fatalf("%v missing from the export", ipcount.Addr(ip))
+		}
+	}
+	if f, err := ipcount.ParseExportFormat("roaring"); err != nil || f != ipcount.ExportRoaring {
+		t.Fatalf("ParseExportFormat: %v, %v", f, err)
+	}
+
+	// a foreign set: half of it in the input, the rest new to the count
+	r := rand.New(rand.NewSource(176))
+	theirs := ipcount.NewSet()
+	extra := 0
+	for _, ip := range randomIPs(r, 30000) {
+		if _, seen := ref[ip]; theirs.Add(ip) && !seen {
+			extra++
+		}
+	}
+	for ip := range ref {
+		if r.Intn(2) == 0 {
+			theirs.Add(ip)
+		}
+	}
+	var data bytes.Buffer
+	if _, err := theirs.WriteRoaring(&data); err != nil {
+		t.Fatal(err)
+	}
+	res, err = c.CountSnapshot(context.Background(), []string{path}, ipcount.Snapshot{Load: bytes.NewReader(data.Bytes())})
+	if err != nil || res.Loaded != theirs.Len() || res.Unique != uint64(len(ref)+extra) {
+		t.Fatalf("load: %+v, %v; want loaded %d, unique %d", res, err, theirs.Len(), len(ref)+extra)
+	}
+	_, err = c.CountSnapshot(context.Background(), []string{path}, ipcount.Snapshot{Load: bytes.NewReader(data.Bytes()[:data.Len()-5])})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !strings.HasPrefix(err.Error(), "load:") {
+		t.Fatalf("truncated load: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// randomIPs draws n addresses: a third spread over the whole space, the rest packed into
+// a few /16s dense enough to turn their groups into bitmaps.
+func randomIPs(r *rand.Rand, n int) []uint32 {
+	dense := []uint32{0x0A000000, 0xC0A80000, 0xFFFF0000, 0}
+	ips := make([]uint32, n)
+	for i := range ips {
+		if i%3 == 0 {
+			ips[i] = r.Uint32()
+		} else {
+			ips[i] = dense[r.Intn(len(dense))] | uint32(r.Intn(1<<16))
+		}
+	}
+	return ips
+}
+
+func setOf(ips []uint32) (*ipcount.Set, map[uint32]bool) {
+	s, ref := ipcount.NewSet(), make(map[uint32]bool)
+	for _, ip := range ips {
+		if s.Add(ip) == ref[ip] {
+			panic("Add reported the wrong novelty")
+		}
+		ref[ip] = true
+	}
+	return s, ref
+}
+
+// checkSet compares s with ref: length, ascending iteration and membership.
+func checkSet(t *testing.T, name string, s *ipcount.Set, ref map[uint32]bool) {
+	t.Helper()
+	if s.Len() != uint64(len(ref)) {
+		t.Fatalf("%s: Len=%d, want %d", name, s.Len(), len(ref))
+	}
+	want := make([]uint32, 0, len(ref))
+	for ip := range ref {
+		want = append(want, ip)
+	}
+	slices.Sort(want)
+	if got := slices.Collect(s.All()); !slices.Equal(got, want) {
+		t.Fatalf("%s: iteration gives %d addresses, want %d ascending", name, len(got), len(want))
+	}
+	r := rand.New(rand.NewSource(int64(len(ref))))
+	for range 10000 {
+		ip := r.Uint32()
+		if r.Intn(2) == 0 && len(want) > 0 {
+			ip = want[r.Intn(len(want))] ^ uint32(r.Intn(2))
+		}
+		if s.Contains(ip) != ref[ip] {
+			t.Fatalf("%s: Contains(%#x)=%v", name, ip, !ref[ip])
+		}
+	}
+}
+
+func TestSet_Operations(t *testing.T) {
+	r := rand.New(rand.NewSource(81))
+	for _, n := range []int{0, 1, 100, 5000, 60000} {
+		a, refA := setOf(randomIPs(r, n))
+		b, refB := setOf(randomIPs(r, n/2+r.Intn(n+1)))
+		checkSet(t, "a", a, refA)
+
+		union, inter, diff := map[uint32]bool{}, map[uint32]bool{}, map[uint32]bool{}
+		for ip := range refA {
+			union[ip] = true
+			if refB[ip] {
+				inter[ip] = true
+			} else {
+				diff[ip] = true
+			}
+		}
+		for ip := range refB {
+			union[ip] = true
+		}
+		checkSet(t, "union", a.Union(b), union)
+		checkSet(t, "intersect", a.Intersect(b), inter)
+		checkSet(t, "difference", a.Difference(b), diff)
+		checkSet(t, "a after operations", a, refA)
+
+		merged := a.Clone()
+		merged.Merge(b)
+		checkSet(t, "merge", merged, union)
+		checkSet(t, "clone source", a, refA)
+	}
+}
+
+func TestSet_LinesAndAddrs(t *testing.T) {
+	var s ipcount.Set
+	for _, line := range []string{"10.0.0.1\n", "10.0.0.2\r\n", "255.255.255.255", "010.000.000.001", "1.2.3\n", "a.b.c.d", ""} {
+		s.AddLine([]byte(line))
+	}
+	want := []string{"10.0.0.1", "10.0.0.2", "255.255.255.255"}
+	var got []string
+	for a := range s.Addrs() {
+		got = append(got, a.String())
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("addresses %v, want %v", got, want)
+	}
+
+	if !s.AddAddr(netip.MustParseAddr("::ffff:1.2.3.4")) || !s.ContainsAddr(netip.MustParseAddr("1.2.3.4")) {
+		t.Fatal("IPv4-mapped address not added as IPv4")
+	}
+	if s.AddAddr(netip.MustParseAddr("2001:db8::1")) || s.ContainsAddr(netip.MustParseAddr("2001:db8::1")) {
+		t.Fatal("IPv6 address accepted")
+	}
+	ip, ok := ipcount.FromAddr(netip.MustParseAddr("192.168.1.2"))
+	if !ok || ip != 0xC0A80102 || ipcount.Addr(ip).String() != "192.168.1.2" {
+		t.Fatalf("FromAddr=%#x,%v", ip, ok)
+	}
+}
+
+func TestSet_CollectAfterScan(t *testing.T) {
+	r := rand.New(rand.NewSource(83))
+	ips := randomIPs(r, 200000)
+	var lines []string
+	ref := make(map[uint32]bool)
+	for i, ip := range ips {
+		if i%5 == 0 {
+			lines = append(lines, "junk\n")
+		}
+		lines = append(lines, ipcount.Addr(ip).String()+"\n")
+		ref[ip] = true
+	}
+	half := len(lines) / 2
+	p1 := writeTempFile(t, "a.txt", lines[:half])
+	p2 := writeTempFile(t, "b.txt", lines[half:])
+	ctx := context.Background()
+
+	for _, shards := range []int{1, 3, 7, 256} {
+		c, err := ipcount.New(ipcount.Options{Shards: shards, Readers: 4, BufMB: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s ipcount.Set
+		s.Add(0x01020304)
+		if _, err := c.CollectFile(ctx, p1, &s); err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.CollectReader(ctx, strings.NewReader(strings.Join(lines[half:], "")), &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Unique != uint64(refUnique(strings.Join(lines[half:], ""))) {
+			t.Fatalf("shards=%d: scan unique=%d", shards, res.Unique)
+		}
+		want := map[uint32]bool{0x01020304: true}
+		for ip := range ref {
+			want[ip] = true
+		}
+		checkSet(t, "incremental", &s, want)
+
+		var all ipcount.Set
+		res, err = c.CollectFiles(ctx, []string{p1, p2}, &all)
+		if err != nil || res.Unique != all.Len() {
+			t.Fatalf("shards=%d: unique=%d, set has %d, err=%v", shards, res.Unique, all.Len(), err)
+		}
+		checkSet(t, "files", &all, ref)
+	}
+}
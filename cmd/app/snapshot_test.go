@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Borislavv/ip-file-counter/internal/snapshot"
+	"github.com/Borislavv/ip-file-counter/pkg/ipcount"
+	"io"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// readSnapshot decodes a snapshot back into addresses.
+func readSnapshot(t *testing.T, data []byte) []uint32 {
+	t.Helper()
+	sr, err := snapshot.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []uint32
+	w := make([]uint64, snapshot.WindowWords)
+	for {
+		lo, _, err := sr.Next(w)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, word := range w {
+			for b := range 64 {
+				if word&(1<<b) != 0 {
+					out = append(out, lo+uint32(k*64+b))
+				}
+			}
+		}
+	}
+	if sr.Len() != uint64(len(out)) {
+		t.Fatalf("Len() = %d, read %d", sr.Len(), len(out))
+	}
+	return out
+}
+
+func TestSnapshot_SaveAndLoadCarryTheSet(t *testing.T) {
+	dayOne, ref := heavyCorpus(151) // a whole /16 makes bitmap records, the rest arrays and empty windows
+	r := rand.New(rand.NewSource(152))
+	var dayTwo []string
+	union := make(map[uint32]bool, len(ref))
+	for ip := range ref {
+		union[ip] = true
+	}
+	for i, ip := range randomIPs(r, 50000) {
+		if i%5 == 0 {
+			ip = uint32(0x0A0B0000 | i) // partly seen on day one
+		}
+		dayTwo = append(dayTwo, ipcount.Addr(ip).String()+"\n")
+		union[ip] = true
+	}
+	want := make([]uint32, 0, len(union))
+	for ip := range union {
+		want = append(want, ip)
+	}
+	slices.Sort(want)
+	one, two := writeTempFile(t, "one.txt", dayOne), writeTempFile(t, "two.txt", dayTwo)
+
+	for _, opts := range []ipcount.Options{
+		{Readers: 3, Shards: 7},
+		{Readers: 2, Shards: 64, Layout: ipcount.LayoutRange, PerFile: true},
+	} {
+		c, err := ipcount.New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, comp := range []ipcount.SnapshotCompression{ipcount.SnapshotNone, ipcount.SnapshotGzip} {
+			freeBitsets()
+			var base, next bytes.Buffer
+			res, err := c.CountSnapshot(context.Background(), []string{one}, ipcount.Snapshot{Save: &base, Compression: comp})
+			if err != nil || res.Unique != uint64(len(ref)) || res.Loaded != 0 {
+				t.Fatalf("%+v %s day one: %+v, %v", opts, comp, res, err)
+			}
+			res, err = c.CountSnapshot(context.Background(), []string{two}, ipcount.Snapshot{Load: bytes.NewReader(base.Bytes()), Save: &next})
+			if err != nil || res.Loaded != uint64(len(ref)) || res.Unique != uint64(len(want)) {
+				t.Fatalf("%+v %s day two: %+v, %v; want loaded %d, unique %d", opts, comp, res, err, len(ref), len(want))
+			}
+			if opts.PerFile && res.Files[0].Added != res.Unique-res.Loaded {
+				t.Fatalf("%+v %s: added %d, newly seen %d", opts, comp, res.Files[0].Added, res.Unique-res.Loaded)
+			}
+			if got := readSnapshot(t, next.Bytes()); !slices.Equal(got, want) {
+				t.Fatalf("%+v %s: snapshot holds %d addresses, want %d", opts, comp, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestSnapshot_EmptyInputKeepsTheLoadedSet(t *testing.T) {
+	var lines []string
+	for _, ip := range randomIPs(rand.New(rand.NewSource(153)), 20000) {
+		lines = append(lines, ipcount.Addr(ip).String()+"\n")
+	}
+	some, empty := writeTempFile(t, "some.txt", lines), writeTempFile(t, "empty.txt", nil)
+	c, err := ipcount.New(ipcount.Options{Readers: 2, Shards: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var base bytes.Buffer
+	if _, err := c.CountSnapshot(context.Background(), []string{some}, ipcount.Snapshot{Save: &base}); err != nil {
+		t.Fatal(err)
+	}
+	want := readSnapshot(t, base.Bytes())
+
+	modes := []ipcount.IOMode{ipcount.IOPread, ipcount.IOMmap, ipcount.IOURing}
+	if directSupported(t, some) {
+		modes = append(modes, ipcount.IODirect)
+	}
+	for _, mode := range modes {
+		freeBitsets()
+		c, err := ipcount.New(ipcount.Options{Readers: 2, Shards: 8, IO: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var next bytes.Buffer
+		res, err := c.CountSnapshot(context.Background(), []string{empty}, ipcount.Snapshot{Load: bytes.NewReader(base.Bytes()), Save: &next})
+		if err != nil || res.Loaded != uint64(len(want)) || res.Unique != uint64(len(want)) {
+			t.Fatalf("io=%s: %+v, %v; want loaded and unique %d", mode, res, err, len(want))
+		}
+		if got := readSnapshot(t, next.Bytes()); !slices.Equal(got, want) {
+			t.Fatalf("io=%s: saved %d addresses, want the %d loaded", mode, len(got), len(want))
+		}
+	}
+
+	// no input at all re-saves the snapshot, here compressed
+	var next bytes.Buffer
+	res, err := c.CountSnapshot(context.Background(), nil, ipcount.Snapshot{Load: bytes.NewReader(base.Bytes()), Save: &next, Compression: ipcount.SnapshotGzip})
+	if err != nil || res.Loaded != uint64(len(want)) || res.Unique != uint64(len(want)) || res.Lines.Lines != 0 {
+		t.Fatalf("no input: %+v, %v; want loaded and unique %d", res, err, len(want))
+	}
+	if got := readSnapshot(t, next.Bytes()); !slices.Equal(got, want) {
+		t.Fatalf("no input: saved %d addresses, want the %d loaded", len(got), len(want))
+	}
+	if _, err := c.CountSnapshot(context.Background(), nil, ipcount.Snapshot{Save: &next}); err != ipcount.ErrNoInput {
+		t.Fatalf("no input, nothing loaded: %v", err)
+	}
+}
+
+func TestSnapshot_RejectsDamage(t *testing.T) {
+	path := writeTempFile(t, "few.txt", []string{"1.1.1.1\n", "10.0.0.1\n", "255.255.255.255\n"})
+	c, err := ipcount.New(ipcount.Options{Shards: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var good bytes.Buffer
+	if _, err := c.CountSnapshot(context.Background(), []string{path}, ipcount.Snapshot{Save: &good}); err != nil {
+		t.Fatal(err)
+	}
+	load := func(data []byte) error {
+		_, err := c.CountSnapshot(context.Background(), []string{path}, ipcount.Snapshot{Load: bytes.NewReader(data)})
+		return err
+	}
+	if err := load(good.Bytes()); err != nil {
+		t.Fatalf("intact: %v", err)
+	}
+	damage := func(at int, b byte) []byte {
+		data := bytes.Clone(good.Bytes())
+		data[at] = b
+		return data
+	}
+	n := good.Len()
+	for name, tc := range map[string]struct {
+		data []byte
+		want error
+	}{
+		"magic":     {damage(0, 'X'), ipcount.ErrSnapshotFormat},
+		"empty":     {nil, ipcount.ErrSnapshotFormat},
+		"reserved":  {damage(12, 1), ipcount.ErrSnapshotFormat},
+		"kind":      {damage(16, 9), ipcount.ErrSnapshotFormat},
+		"checksum":  {damage(n-1, good.Bytes()[n-1]^1), ipcount.ErrSnapshotChecksum},
+		"count":     {damage(n-12, good.Bytes()[n-12]+1), ipcount.ErrSnapshotChecksum},
+		"truncated": {good.Bytes()[:n-3], io.ErrUnexpectedEOF},
+	} {
+		if err := load(tc.data); !errors.Is(err, tc.want) || !strings.HasPrefix(err.Error(), "load:") {
+			t.Fatalf("%s: %v, want %v", name, err, tc.want)
+		}
+	}
+	if err := load(damage(6, 2)); err == nil || !strings.Contains(err.Error(), "version 2") {
+		t.Fatalf("version 2: %v", err)
+	}
+	// the array record of the first window: flipping an offset breaks its checksum or its order
+	if err := load(damage(21, 0x7F)); err == nil {
+		t.Fatal("damaged offset accepted")
+	}
+	if _, err := ipcount.ParseSnapshotCompression("zstd"); err == nil {
+		t.Fatal("ParseSnapshotCompression accepted zstd")
+	}
+	var out bytes.Buffer
+	if _, err := c.CountSnapshot(context.Background(), []string{path}, ipcount.Snapshot{Save: &out, Compression: 9}); err == nil || out.Len() != 0 {
+		t.Fatalf("compression 9: %v, %d bytes", err, out.Len())
+	}
+}
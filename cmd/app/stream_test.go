@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// randomLines builds a corpus bigger than a 1 MiB block so that streaming crosses block boundaries.
+func randomLines(seed int64, n int) []string {
+	r := rand.New(rand.NewSource(seed))
+	lines := make([]string, 0, n+8)
+	for i := 0; i < n; i++ {
+		end := "\n"
+		if r.Intn(4) == 0 {
+			end = "\r\n"
+		}
+		lines = append(lines, ipToString(r.Intn(256), r.Intn(256), r.Intn(256), r.Intn(256), r.Intn(3) == 0)+end)
+		if r.Intn(7) == 0 {
+			lines = append(lines, lines[len(lines)-1])
+		}
+	}
+	return append(lines, "a.b.c.d\n", "\n", "1.2.3\n", "7.7.7.7")
+}
+
+func TestStream_MatchesReadAt(t *testing.T) {
+	lines := randomLines(7, 200000)
+	path := writeTempFile(t, "stream.txt", lines)
+	want := refUniqueIPv4Count(t, path)
+
+	fromFile, err := read.UniqueIPv4Count(path, 8, 3, 1, 4)
+	if err != nil {
+		t.Fatalf("readAt err: %v", err)
+	}
+	if int(fromFile) != want {
+		t.Fatalf("readAt count=%d, want %d", fromFile, want)
+	}
+
+	data := []byte(strings.Join(lines, ""))
+	for _, R := range []int{1, 4} {
+		got, err := read.UniqueIPv4CountReader(iotest.HalfReader(bytes.NewReader(data)), 8, R, 1)
+		if err != nil {
+			t.Fatalf("stream err (R=%d): %v", R, err)
+		}
+		if got != fromFile {
+			t.Fatalf("R=%d: stream count=%d, readAt %d", R, got, fromFile)
+		}
+	}
+}
+
+func TestStream_LongLineSkipped(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("1.1.1.1\n")
+	sb.WriteString(strings.Repeat("9", 3<<20)) // longer than a whole 1 MiB block
+	sb.WriteString("\n2.2.2.2\n3.3.3.3")
+
+	got, err := read.UniqueIPv4CountReader(strings.NewReader(sb.String()), 4, 2, 1)
+	if err != nil {
+		t.Fatalf("stream err: %v", err)
+	}
+	if got != 3 {
+		t.Fatalf("count=%d, want 3", got)
+	}
+}
+
+// TestStream_RefillsAfterSkipping has lines longer than a whole block followed by an address cut
+// by the next block end: the stream must refill before parsing what's left after the skipped line.
+func TestStream_RefillsAfterSkipping(t *testing.T) {
+	const mib = 1 << 20
+	for _, xs := range []int{2*mib - 5, 3*mib - 5} { // the long line spans two blocks, or three
+		data := strings.Repeat("x", xs) + "\n1.2.3.4\n5.6.7.8\n"
+		for _, R := range []int{1, 3} {
+			got, err := read.UniqueIPv4CountReader(strings.NewReader(data), 4, R, 1)
+			if err != nil || got != 2 {
+				t.Fatalf("%d x's, R=%d: count=%d, %v; want 2", xs, R, got, err)
+			}
+		}
+
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("pipe: %v", err)
+		}
+		go func() {
+			_, _ = pw.WriteString(data)
+			_ = pw.Close()
+		}()
+		got, err := read.UniqueIPv4CountReader(pr, 4, 2, 1)
+		_ = pr.Close()
+		if err != nil || got != 2 {
+			t.Fatalf("%d x's, pipe: count=%d, %v; want 2", xs, got, err)
+		}
+	}
+}
+
+func TestStream_ReadError(t *testing.T) {
+	r := iotest.TimeoutReader(strings.NewReader(strings.Repeat("1.2.3.4\n", 1<<18)))
+	if _, err := read.UniqueIPv4CountReader(r, 2, 2, 1); err == nil {
+		t.Fatalf("expected read error")
+	}
+}
+
+func TestStream_MockFile(t *testing.T) {
+	f, err := os.Open(benchFilePath)
+	if err != nil {
+		t.Skipf("open %s: %v", benchFilePath, err)
+	}
+	defer f.Close()
+
+	got, err := read.UniqueIPv4CountReader(f, 16, 4, 1)
+	if err != nil {
+		t.Fatalf("stream err: %v", err)
+	}
+	if got != 42573 {
+		t.Fatalf("count=%d, want 42573", got)
+	}
+}
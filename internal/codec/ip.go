@@ -25,7 +25,7 @@ func ParseIPv4(b []byte) (uint32, bool) {
 	i = n + 1
 
 	a3, n = dec3(b, i)
-	if a3 > 255 {
+	if n == i || a3 > 255 {
 		return 0, false
 	}
 
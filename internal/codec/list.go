@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// ListFormat is an encoding of an ascending list of IPv4s.
+type ListFormat int
+
+const (
+	ListText    ListFormat = iota // dotted quads, one per line
+	ListRaw                       // 4-byte big-endian uint32s
+	ListVarint                    // uvarint of the gap to the previous address (the first one's is from 0)
+	ListRoaring                   // a portable Roaring bitmap: a whole-list encoding, which Append doesn't produce
+)
+
+var listFormatNames = [...]string{
+	ListText:    "text",
+	ListRaw:     "raw",
+	ListVarint:  "varint",
+	ListRoaring: "roaring",
+}
+
+func (f ListFormat) String() string {
+	if f >= 0 && int(f) < len(listFormatNames) {
+		return listFormatNames[f]
+	}
+	return fmt.Sprintf("ListFormat(%d)", int(f))
+}
+
+// ParseListFormat maps a format name ("text", "raw", "varint", "roaring") to its ListFormat.
+func ParseListFormat(s string) (ListFormat, error) {
+	for f, name := range listFormatNames {
+		if name == s {
+			return ListFormat(f), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown list format %q", s)
+}
+
+// Append appends ip, which follows prev in the list (prev is 0 for the first one), to dst.
+// ListRoaring has no per-address form: it appends as ListText.
+func (f ListFormat) Append(dst []byte, ip, prev uint32) []byte {
+	switch f {
+	case ListRaw:
+		return binary.BigEndian.AppendUint32(dst, ip)
+	case ListVarint:
+		return binary.AppendUvarint(dst, uint64(ip-prev))
+	default:
+		return append(AppendIPv4(dst, ip), '\n')
+	}
+}
+
+// AppendIPv4 appends the dotted-quad form of ip to dst.
+func AppendIPv4(dst []byte, ip uint32) []byte {
+	dst = strconv.AppendUint(dst, uint64(ip>>24), 10)
+	dst = append(dst, '.')
+	dst = strconv.AppendUint(dst, uint64(ip>>16&0xFF), 10)
+	dst = append(dst, '.')
+	dst = strconv.AppendUint(dst, uint64(ip>>8&0xFF), 10)
+	dst = append(dst, '.')
+	return strconv.AppendUint(dst, uint64(ip&0xFF), 10)
+}
@@ -0,0 +1,198 @@
+package ipset
+
+import (
+	"math/bits"
+	"slices"
+)
+
+// arrayMax is the largest container kept as a sorted array; bigger ones are 8 KiB bitmaps.
+const arrayMax = 4096
+
+// bitmapWords is the size of a container bitmap: one bit per low half of an address.
+const bitmapWords = 1 << 16 / 64
+
+// container holds the low 16 bits of the addresses sharing one high half:
+// a sorted array while small, a bitmap once it would outgrow one.
+type container struct {
+	arr []uint16             // sorted low halves, used while bm is nil
+	bm  *[bitmapWords]uint64 // one bit per low half
+	n   int                  // cardinality
+}
+
+func (c *container) contains(lo uint16) bool {
+	if c.bm != nil {
+		return c.bm[lo>>6]&(1<<(lo&63)) != 0
+	}
+	_, ok := slices.BinarySearch(c.arr, lo)
+	return ok
+}
+
+// add inserts lo and reports whether it was missing.
+func (c *container) add(lo uint16) bool {
+	if c.bm != nil {
+		w, m := lo>>6, uint64(1)<<(lo&63)
+		if c.bm[w]&m != 0 {
+			return false
+		}
+		c.bm[w] |= m
+		c.n++
+		return true
+	}
+	i, ok := slices.BinarySearch(c.arr, lo)
+	if ok {
+		return false
+	}
+	c.arr = slices.Insert(c.arr, i, lo)
+	c.n++
+	if c.n > arrayMax {
+		c.bm, c.arr = c.bitmap(), nil
+	}
+	return true
+}
+
+// each yields the low halves in ascending order until yield returns false, and reports whether it got to the end.
+func (c *container) each(yield func(uint16) bool) bool {
+	if c.bm == nil {
+		for _, lo := range c.arr {
+			if !yield(lo) {
+				return false
+			}
+		}
+		return true
+	}
+	for w, word := range c.bm {
+		for word != 0 {
+			b := bits.TrailingZeros64(word)
+			word &= word - 1
+			if !yield(uint16(w<<6 | b)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *container) clone() container {
+	if c.bm != nil {
+		bm := *c.bm
+		return container{bm: &bm, n: c.n}
+	}
+	return container{arr: slices.Clone(c.arr), n: c.n}
+}
+
+// bitmap returns c as a fresh bitmap.
+func (c *container) bitmap() *[bitmapWords]uint64 {
+	bm := new([bitmapWords]uint64)
+	if c.bm != nil {
+		*bm = *c.bm
+		return bm
+	}
+	for _, lo := range c.arr {
+		bm[lo>>6] |= 1 << (lo & 63)
+	}
+	return bm
+}
+
+// fromBitmap makes a container of bm, which it takes over unless it ends up an array.
+func fromBitmap(bm *[bitmapWords]uint64) container {
+	n := 0
+	for _, w := range bm {
+		n += bits.OnesCount64(w)
+	}
+	if n > arrayMax {
+		return container{bm: bm, n: n}
+	}
+	c := container{arr: make([]uint16, 0, n), n: n}
+	for w, word := range bm {
+		for word != 0 {
+			c.arr = append(c.arr, uint16(w<<6|bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+	return c
+}
+
+// fromWords makes a container of the bits in w (bitmapWords of them), copying them.
+func fromWords(w []uint64) container {
+	var bm [bitmapWords]uint64
+	copy(bm[:], w)
+	return fromBitmap(&bm)
+}
+
+func union(a, b *container) container {
+	if a.bm == nil && b.bm == nil && a.n+b.n <= arrayMax {
+		out := make([]uint16, 0, a.n+b.n)
+		i, j := 0, 0
+		for i < len(a.arr) && j < len(b.arr) {
+			switch x, y := a.arr[i], b.arr[j]; {
+			case x < y:
+				out = append(out, x)
+				i++
+			case x > y:
+				out = append(out, y)
+				j++
+			default:
+				out = append(out, x)
+				i++
+				j++
+			}
+		}
+		out = append(append(out, a.arr[i:]...), b.arr[j:]...)
+		return container{arr: out, n: len(out)}
+	}
+	bm := a.bitmap()
+	if b.bm == nil {
+		for _, lo := range b.arr {
+			bm[lo>>6] |= 1 << (lo & 63)
+		}
+	} else {
+		for w := range bm {
+			bm[w] |= b.bm[w]
+		}
+	}
+	return fromBitmap(bm)
+}
+
+func intersect(a, b *container) container {
+	if a.bm == nil || b.bm == nil {
+		small, other := a, b
+		if small.bm != nil {
+			small, other = b, a
+		}
+		var out []uint16
+		for _, lo := range small.arr {
+			if other.contains(lo) {
+				out = append(out, lo)
+			}
+		}
+		return container{arr: out, n: len(out)}
+	}
+	bm := new([bitmapWords]uint64)
+	for w := range bm {
+		bm[w] = a.bm[w] & b.bm[w]
+	}
+	return fromBitmap(bm)
+}
+
+func difference(a, b *container) container {
+	if a.bm == nil {
+		var out []uint16
+		for _, lo := range a.arr {
+			if !b.contains(lo) {
+				out = append(out, lo)
+			}
+		}
+		return container{arr: out, n: len(out)}
+	}
+	bm := a.bitmap()
+	if b.bm == nil {
+		for _, lo := range b.arr {
+			bm[lo>>6] &^= 1 << (lo & 63)
+		}
+	} else {
+		for w := range bm {
+			bm[w] &^= b.bm[w]
+		}
+	}
+	return fromBitmap(bm)
+}
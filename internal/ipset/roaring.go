@@ -0,0 +1,277 @@
+package ipset
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// The portable Roaring format (github.com/RoaringBitmap/RoaringFormatSpec) is what the C, Java, Go
+// and Python Roaring libraries serialize 32-bit bitmaps as: a cookie, the container keys with their
+// cardinalities, their offsets, then the containers themselves, little-endian.
+const (
+	roaringCookieNoRuns = 12346 // followed by a uint32 container count, then offsets
+	roaringCookie       = 12347 // count-1 in the high 16 bits, then a bitset of the run containers
+	roaringNoOffsetMax  = 4     // bitmaps with runs and fewer containers have no offsets
+)
+
+// ErrRoaring is returned for input that isn't a well-formed portable Roaring bitmap.
+var ErrRoaring = errors.New("ipset: malformed roaring bitmap")
+
+// runs returns how many runs of consecutive low halves c holds.
+func (c *container) runs() int {
+	if c.bm == nil {
+		r := 0
+		for i, lo := range c.arr {
+			if i == 0 || lo != c.arr[i-1]+1 {
+				r++
+			}
+		}
+		return r
+	}
+	r, carry := 0, uint64(0)
+	for _, w := range c.bm {
+		r += bits.OnesCount64(w &^ (w<<1 | carry)) // run starts: set bits whose lower neighbour isn't
+		carry = w >> 63
+	}
+	return r
+}
+
+// roaringKind is how a container is serialized: 0 array, 1 bitmap, 2 run, whichever is smallest
+// (array and bitmap being told apart by cardinality alone).
+func (c *container) roaringKind() (kind, size int) {
+	kind, size = 0, 2*c.n
+	if c.n > arrayMax {
+		kind, size = 1, bitmapWords*8
+	}
+	if rs := 2 + 4*c.runs(); rs < size {
+		kind, size = 2, rs
+	}
+	return kind, size
+}
+
+// WriteRoaring writes the set as a portable Roaring bitmap, with run containers where they're smaller,
+// and returns the number of bytes written.
+func (s *Set) WriteRoaring(w io.Writer) (int64, error) {
+	n := len(s.keys)
+	kinds := make([]int, n)
+	sizes := make([]int, n)
+	hasRuns := false
+	for i := range s.cs {
+		kinds[i], sizes[i] = s.cs[i].roaringKind()
+		hasRuns = hasRuns || kinds[i] == 2
+	}
+
+	var hdr []byte
+	if hasRuns {
+		hdr = binary.LittleEndian.AppendUint32(hdr, roaringCookie|uint32(n-1)<<16)
+		runs := make([]byte, (n+7)/8)
+		for i, k := range kinds {
+			if k == 2 {
+				runs[i/8] |= 1 << (i % 8)
+			}
+		}
+		hdr = append(hdr, runs...)
+	} else {
+		hdr = binary.LittleEndian.AppendUint32(hdr, roaringCookieNoRuns)
+		hdr = binary.LittleEndian.AppendUint32(hdr, uint32(n))
+	}
+	for i, key := range s.keys {
+		hdr = binary.LittleEndian.AppendUint16(hdr, key)
+		hdr = binary.LittleEndian.AppendUint16(hdr, uint16(s.cs[i].n-1))
+	}
+	if !hasRuns || n >= roaringNoOffsetMax {
+		off := len(hdr) + 4*n
+		for _, size := range sizes {
+			hdr = binary.LittleEndian.AppendUint32(hdr, uint32(off))
+			off += size
+		}
+	}
+
+	bw := bufio.NewWriterSize(w, 1<<16)
+	written := int64(len(hdr))
+	if _, err := bw.Write(hdr); err != nil {
+		return 0, err
+	}
+	var b []byte
+	for i := range s.cs {
+		c := &s.cs[i]
+		b = b[:0]
+		switch kinds[i] {
+		case 0:
+			c.each(func(lo uint16) bool {
+				b = binary.LittleEndian.AppendUint16(b, lo)
+				return true
+			})
+		case 1:
+			for _, word := range c.bitmap() {
+				b = binary.LittleEndian.AppendUint64(b, word)
+			}
+		case 2:
+			b = binary.LittleEndian.AppendUint16(b, uint16(c.runs()))
+			start, last := -1, -1
+			c.each(func(lo uint16) bool {
+				if int(lo) != last+1 || start < 0 {
+					if start >= 0 {
+						b = binary.LittleEndian.AppendUint16(b, uint16(start))
+						b = binary.LittleEndian.AppendUint16(b, uint16(last-start))
+					}
+					start = int(lo)
+				}
+				last = int(lo)
+				return true
+			})
+			b = binary.LittleEndian.AppendUint16(b, uint16(start))
+			b = binary.LittleEndian.AppendUint16(b, uint16(last-start))
+		}
+		if _, err := bw.Write(b); err != nil {
+			return written, err
+		}
+		written += int64(len(b))
+	}
+	return written, bw.Flush()
+}
+
+// ReadRoaring reads a portable Roaring bitmap from r, reading no further than its end.
+// Malformed input comes back as ErrRoaring, truncated input as io.ErrUnexpectedEOF, both wrapped.
+func ReadRoaring(r io.Reader) (*Set, error) {
+	rr := roaringReader{r: r}
+	cookie := rr.uint32()
+	var n int
+	var runs []byte
+	switch {
+	case cookie == roaringCookieNoRuns:
+		n = int(rr.uint32())
+		if rr.err == nil && n > 1<<16 {
+			return nil, fmt.Errorf("%w: %d containers", ErrRoaring, n)
+		}
+	case cookie&0xFFFF == roaringCookie:
+		n = int(cookie>>16) + 1
+		runs = rr.bytes((n + 7) / 8)
+	case rr.err == nil:
+		return nil, fmt.Errorf("%w: cookie %#x", ErrRoaring, cookie)
+	}
+	desc := rr.bytes(4 * n)
+	if runs == nil || n >= roaringNoOffsetMax {
+		rr.bytes(4 * n) // offsets: the containers follow each other anyway
+	}
+	if rr.err != nil {
+		return nil, rr.err
+	}
+
+	s := New()
+	for i := range n {
+		key := binary.LittleEndian.Uint16(desc[4*i:])
+		card := int(binary.LittleEndian.Uint16(desc[4*i+2:])) + 1
+		if i > 0 && key <= s.keys[len(s.keys)-1] {
+			return nil, fmt.Errorf("%w: keys out of order", ErrRoaring)
+		}
+		var c container
+		var err error
+		switch {
+		case runs != nil && runs[i/8]&(1<<(i%8)) != 0:
+			c, err = rr.runContainer()
+		case card <= arrayMax:
+			c, err = rr.arrayContainer(card)
+		default:
+			c, err = rr.bitmapContainer()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c.n != card {
+			return nil, fmt.Errorf("%w: container %#x holds %d values, not %d", ErrRoaring, key, c.n, card)
+		}
+		s.push(key, c)
+	}
+	return s, nil
+}
+
+// roaringReader reads little-endian values, keeping the first error.
+type roaringReader struct {
+	r   io.Reader
+	err error
+}
+
+func (rr *roaringReader) bytes(n int) []byte {
+	if rr.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rr.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		rr.err = fmt.Errorf("ipset: roaring: %w", err)
+		return nil
+	}
+	return b
+}
+
+func (rr *roaringReader) uint32() uint32 {
+	if b := rr.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (rr *roaringReader) arrayContainer(card int) (container, error) {
+	b := rr.bytes(2 * card)
+	if rr.err != nil {
+		return container{}, rr.err
+	}
+	arr := make([]uint16, card)
+	for i := range arr {
+		arr[i] = binary.LittleEndian.Uint16(b[2*i:])
+		if i > 0 && arr[i] <= arr[i-1] {
+			return container{}, fmt.Errorf("%w: array values out of order", ErrRoaring)
+		}
+	}
+	return container{arr: arr, n: card}, nil
+}
+
+func (rr *roaringReader) bitmapContainer() (container, error) {
+	b := rr.bytes(bitmapWords * 8)
+	if rr.err != nil {
+		return container{}, rr.err
+	}
+	bm := new([bitmapWords]uint64)
+	n := 0
+	for k := range bm {
+		bm[k] = binary.LittleEndian.Uint64(b[8*k:])
+		n += bits.OnesCount64(bm[k])
+	}
+	if n <= arrayMax {
+		return fromBitmap(bm), nil // a valid file keeps these as arrays: the count check will tell
+	}
+	return container{bm: bm, n: n}, nil
+}
+
+func (rr *roaringReader) runContainer() (container, error) {
+	hb := rr.bytes(2)
+	if rr.err != nil {
+		return container{}, rr.err
+	}
+	nr := int(binary.LittleEndian.Uint16(hb))
+	b := rr.bytes(4 * nr)
+	if rr.err != nil {
+		return container{}, rr.err
+	}
+	bm := new([bitmapWords]uint64)
+	next := 0 // runs must ascend without overlapping
+	for i := range nr {
+		start := int(binary.LittleEndian.Uint16(b[4*i:]))
+		end := start + int(binary.LittleEndian.Uint16(b[4*i+2:]))
+		if start < next || end > 0xFFFF {
+			return container{}, fmt.Errorf("%w: runs out of order", ErrRoaring)
+		}
+		for v := start; v <= end; v++ {
+			bm[v>>6] |= 1 << (v & 63)
+		}
+		next = end + 1
+	}
+	return fromBitmap(bm), nil
+}
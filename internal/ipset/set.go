@@ -0,0 +1,233 @@
+// Package ipset is an in-memory set of IPv4 addresses. Addresses are grouped by their high 16 bits;
+// each group is a sorted array while it holds at most 4096 addresses and an 8 KiB bitmap beyond,
+// so a set costs little when sparse and at most 512 MiB when full.
+package ipset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"iter"
+	"net/netip"
+	"slices"
+
+	"github.com/Borislavv/ip-file-counter/internal/codec"
+)
+
+// Set is a set of IPv4 addresses held as host-order uint32s (A<<24 | B<<16 | C<<8 | D).
+// The zero value is an empty set ready to use. A Set is not safe for concurrent use
+// unless every goroutine only reads it.
+type Set struct {
+	keys []uint16    // high halves present, ascending
+	cs   []container // cs[i] holds the low halves under keys[i], never empty
+	n    uint64
+}
+
+// New returns an empty set.
+func New() *Set { return new(Set) }
+
+func (s *Set) find(hi uint16) (int, bool) { return slices.BinarySearch(s.keys, hi) }
+
+// Add inserts ip and reports whether it was missing.
+func (s *Set) Add(ip uint32) bool {
+	hi, lo := uint16(ip>>16), uint16(ip)
+	i, ok := s.find(hi)
+	if !ok {
+		s.keys = slices.Insert(s.keys, i, hi)
+		s.cs = slices.Insert(s.cs, i, container{})
+	}
+	if !s.cs[i].add(lo) {
+		return false
+	}
+	s.n++
+	return true
+}
+
+// AddLine parses line as dotted IPv4 text, with an optional "\n" or "\r\n" ending, and adds it.
+// It reports whether the line held an address; malformed lines leave the set untouched.
+func (s *Set) AddLine(line []byte) bool {
+	ip, ok := codec.ParseIPv4(bytes.TrimSuffix(line, []byte("\n")))
+	if ok {
+		s.Add(ip)
+	}
+	return ok
+}
+
+// Contains reports whether ip is in the set.
+func (s *Set) Contains(ip uint32) bool {
+	i, ok := s.find(uint16(ip >> 16))
+	return ok && s.cs[i].contains(uint16(ip))
+}
+
+// Len returns the number of addresses in the set.
+func (s *Set) Len() uint64 { return s.n }
+
+// All yields the addresses in ascending order. The set must not change during the iteration.
+func (s *Set) All() iter.Seq[uint32] {
+	return func(yield func(uint32) bool) {
+		for i := range s.cs {
+			hi := uint32(s.keys[i]) << 16
+			if !s.cs[i].each(func(lo uint16) bool { return yield(hi | uint32(lo)) }) {
+				return
+			}
+		}
+	}
+}
+
+// Clone returns a copy of s.
+func (s *Set) Clone() *Set {
+	out := &Set{keys: slices.Clone(s.keys), cs: make([]container, len(s.cs)), n: s.n}
+	for i := range s.cs {
+		out.cs[i] = s.cs[i].clone()
+	}
+	return out
+}
+
+// Union returns a new set with the addresses of s and o.
+func (s *Set) Union(o *Set) *Set {
+	out := new(Set)
+	i, j := 0, 0
+	for i < len(s.keys) || j < len(o.keys) {
+		switch {
+		case j == len(o.keys) || i < len(s.keys) && s.keys[i] < o.keys[j]:
+			out.push(s.keys[i], s.cs[i].clone())
+			i++
+		case i == len(s.keys) || o.keys[j] < s.keys[i]:
+			out.push(o.keys[j], o.cs[j].clone())
+			j++
+		default:
+			out.push(s.keys[i], union(&s.cs[i], &o.cs[j]))
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// Intersect returns a new set with the addresses in both s and o.
+func (s *Set) Intersect(o *Set) *Set {
+	out := new(Set)
+	for i, j := 0, 0; i < len(s.keys) && j < len(o.keys); {
+		switch {
+		case s.keys[i] < o.keys[j]:
+			i++
+		case s.keys[i] > o.keys[j]:
+			j++
+		default:
+			out.push(s.keys[i], intersect(&s.cs[i], &o.cs[j]))
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// Difference returns a new set with the addresses of s that are not in o.
+func (s *Set) Difference(o *Set) *Set {
+	out := new(Set)
+	j := 0
+	for i, hi := range s.keys {
+		for j < len(o.keys) && o.keys[j] < hi {
+			j++
+		}
+		if j < len(o.keys) && o.keys[j] == hi {
+			out.push(hi, difference(&s.cs[i], &o.cs[j]))
+		} else {
+			out.push(hi, s.cs[i].clone())
+		}
+	}
+	return out
+}
+
+// Merge adds every address of o to s.
+func (s *Set) Merge(o *Set) {
+	if len(s.keys) == 0 || len(o.keys) > 0 && o.keys[0] > s.keys[len(s.keys)-1] {
+		for i := range o.cs {
+			s.push(o.keys[i], o.cs[i].clone()) // o lies past s: append
+		}
+		return
+	}
+	*s = *s.Union(o)
+}
+
+// AddBits adds the addresses whose bits are set in w, bit b of w[k] standing for lo + 64*k + b.
+// The lo must be a multiple of 65536 and w a whole number of 1024-word groups;
+// it's the fast way to load a set from a plain bitset, ascending group after group.
+func (s *Set) AddBits(lo uint32, w []uint64) {
+	if lo&0xFFFF != 0 || len(w)%bitmapWords != 0 {
+		panic(fmt.Sprintf("ipset: AddBits at %#x with %d words", lo, len(w)))
+	}
+	for k := 0; k < len(w); k += bitmapWords {
+		group := w[k : k+bitmapWords]
+		if !slices.ContainsFunc(group, func(x uint64) bool { return x != 0 }) {
+			continue
+		}
+		hi := uint16((lo >> 16) + uint32(k/bitmapWords))
+		c := fromWords(group)
+		i, ok := s.find(hi)
+		switch {
+		case !ok && i == len(s.keys):
+			s.push(hi, c)
+		case !ok:
+			s.keys = slices.Insert(s.keys, i, hi)
+			s.cs = slices.Insert(s.cs, i, c)
+			s.n += uint64(c.n)
+		default:
+			s.n -= uint64(s.cs[i].n)
+			s.cs[i] = union(&s.cs[i], &c)
+			s.n += uint64(s.cs[i].n)
+		}
+	}
+}
+
+// push appends a container under a key past the last one, dropping it if empty.
+func (s *Set) push(hi uint16, c container) {
+	if c.n == 0 {
+		return
+	}
+	s.keys = append(s.keys, hi)
+	s.cs = append(s.cs, c)
+	s.n += uint64(c.n)
+}
+
+// AddAddr adds an IPv4 (or IPv4-mapped IPv6) address and reports whether it was missing.
+// Other IPv6 addresses are not added.
+func (s *Set) AddAddr(a netip.Addr) bool {
+	ip, ok := FromAddr(a)
+	return ok && s.Add(ip)
+}
+
+// ContainsAddr reports whether the IPv4 (or IPv4-mapped IPv6) address a is in the set.
+func (s *Set) ContainsAddr(a netip.Addr) bool {
+	ip, ok := FromAddr(a)
+	return ok && s.Contains(ip)
+}
+
+// Addrs yields the addresses in ascending order as netip.Addr values.
+func (s *Set) Addrs() iter.Seq[netip.Addr] {
+	return func(yield func(netip.Addr) bool) {
+		for ip := range s.All() {
+			if !yield(Addr(ip)) {
+				return
+			}
+		}
+	}
+}
+
+// Addr converts ip to a netip.Addr.
+func Addr(ip uint32) netip.Addr {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], ip)
+	return netip.AddrFrom4(b)
+}
+
+// FromAddr converts an IPv4 or IPv4-mapped IPv6 address to its uint32 form;
+// ok is false for any other address.
+func FromAddr(a netip.Addr) (ip uint32, ok bool) {
+	a = a.Unmap()
+	if !a.Is4() {
+		return 0, false
+	}
+	b := a.As4()
+	return binary.BigEndian.Uint32(b[:]), true
+}
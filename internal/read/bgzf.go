@@ -0,0 +1,174 @@
+package read
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// bgzfHeaderMax bounds the bytes read to recognize a BGZF block header (fixed part + extra field).
+const bgzfHeaderMax = 64
+
+// bgzfBlockSize returns the total size of the BGZF block whose header is at the start of hdr,
+// or 0 if hdr is not a BGZF block header (a gzip member with a "BC" extra subfield holding BSIZE).
+func bgzfBlockSize(hdr []byte) int {
+	if len(hdr) < 18 || hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[2] != 8 || hdr[3]&0x04 == 0 || hdr[3]&0xe0 != 0 {
+		return 0
+	}
+	xlen := int(binary.LittleEndian.Uint16(hdr[10:12]))
+	extra := hdr[12:]
+	if len(extra) > xlen {
+		extra = extra[:xlen]
+	}
+	for len(extra) >= 4 {
+		slen := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if extra[0] == 'B' && extra[1] == 'C' && slen == 2 && len(extra) >= 6 {
+			return int(binary.LittleEndian.Uint16(extra[4:6])) + 1
+		}
+		if len(extra) < 4+slen {
+			return 0
+		}
+		extra = extra[4+slen:]
+	}
+	return 0
+}
+
+// bgzfBlockAt returns the size of the BGZF block starting at off, 0 if there is none.
+func bgzfBlockAt(ra io.ReaderAt, off, size int64) int64 {
+	var hdr [bgzfHeaderMax]byte
+	n, _ := ra.ReadAt(hdr[:], off)
+	bs := int64(bgzfBlockSize(hdr[:n]))
+	if bs == 0 || off+bs > size {
+		return 0
+	}
+	return bs
+}
+
+// bgzfChainAt reports whether off starts a run of BGZF blocks: a few consecutive headers,
+// each one exactly where the previous block's BSIZE says, or the file end.
+func bgzfChainAt(ra io.ReaderAt, off, size int64) bool {
+	const links = 3
+	for i := 0; i < links; i++ {
+		bs := bgzfBlockAt(ra, off, size)
+		if bs == 0 {
+			return false
+		}
+		if off += bs; off == size {
+			return true
+		}
+	}
+	return true
+}
+
+// addBGZFFile decodes a BGZF (block gzip) file with R parallel readers. Ranges are cut on block
+// boundaries taken from the "<path>.gzi" index when one is present, or found by walking block headers.
+func (s *shardSet) addBGZFFile(f *os.File, path string, size int64) error {
+	R := s.cfg.Readers
+	starts := bgzfIndexStarts(f, path, size, R)
+	if starts == nil {
+		starts = bgzfScanStarts(f, size, R)
+	}
+	return s.addRanges(len(starts), func(i int, m meter) (io.Reader, error) {
+		hi := size
+		if i+1 < len(starts) {
+			hi = starts[i+1]
+		}
+		return newGzipRange(f, path, starts[i], hi, m)
+	})
+}
+
+// bgzfScanStarts picks, for each of the R-1 split points, the first block start at or after it.
+func bgzfScanStarts(ra io.ReaderAt, size int64, R int) []int64 {
+	found := make([]int64, R)
+	var wg sync.WaitGroup
+	for i := 1; i < R; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			found[i] = findBGZFBlock(ra, size*int64(i)/int64(R), size)
+		}(i)
+	}
+	wg.Wait()
+	return increasingStarts(found[1:])
+}
+
+// findBGZFBlock scans forward from lo for a verified block start, -1 if none.
+func findBGZFBlock(ra io.ReaderAt, lo, size int64) int64 {
+	const window = 1 << 17 // two max-size blocks: a block start is always within the first one
+	magic := []byte{0x1f, 0x8b, 0x08, 0x04}
+	buf := make([]byte, window+len(magic)-1)
+	for pos := lo; pos < size; pos += window {
+		n, err := ra.ReadAt(buf, pos)
+		if n == 0 && err != nil {
+			return -1
+		}
+		chunk := buf[:n]
+		for i := 0; ; {
+			k := bytes.Index(chunk[i:], magic)
+			if k < 0 {
+				break
+			}
+			if off := pos + int64(i+k); bgzfChainAt(ra, off, size) {
+				return off
+			}
+			i += k + 1
+		}
+	}
+	return -1
+}
+
+// bgzfIndexStarts reads split points from "<path>.gzi": a little-endian uint64 entry count followed
+// by (compressed, uncompressed) uint64 offset pairs of every block but the first.
+// The index only saves a scan: it returns nil when there's none, it can't be read whole
+// or its offsets don't hit block headers, and blocks are found by scanning instead.
+func bgzfIndexStarts(ra io.ReaderAt, path string, size int64, R int) []int64 {
+	idx, err := os.Open(path + ".gzi")
+	if err != nil {
+		return nil
+	}
+	defer idx.Close()
+
+	br := bufio.NewReaderSize(idx, 1<<20)
+	var count uint64
+	if err := binary.Read(br, binary.LittleEndian, &count); err != nil {
+		return nil
+	}
+	offs := make([]int64, 0, min(count, 1<<24))
+	var pair [16]byte
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(br, pair[:]); err != nil {
+			return nil // truncated
+		}
+		offs = append(offs, int64(binary.LittleEndian.Uint64(pair[:8])))
+	}
+
+	found := make([]int64, 0, R-1)
+	for i := 1; i < R; i++ {
+		target := size * int64(i) / int64(R)
+		k := sort.Search(len(offs), func(j int) bool { return offs[j] >= target })
+		if k == len(offs) || offs[k] >= size {
+			break
+		}
+		if bgzfBlockAt(ra, offs[k], size) == 0 {
+			return nil // stale or foreign index
+		}
+		found = append(found, offs[k])
+	}
+	return increasingStarts(found)
+}
+
+// increasingStarts turns candidate offsets (-1 for none) into range starts: 0 followed by
+// the strictly increasing candidates.
+func increasingStarts(found []int64) []int64 {
+	starts := []int64{0}
+	for _, off := range found {
+		if off > starts[len(starts)-1] {
+			starts = append(starts, off)
+		}
+	}
+	return starts
+}
@@ -0,0 +1,154 @@
+package read
+
+import (
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Borislavv/ip-file-counter/internal/ipset"
+	"github.com/Borislavv/ip-file-counter/internal/snapshot"
+)
+
+// windowBits is the log2 of the address window ascend lays out at a time (128 KiB of bitset),
+// a snapshot record's.
+const windowBits = snapshot.WindowBits
+
+// ascend walks the addresses pick selects window by window in address order. Parallel workers lay every window
+// out as a plain bitset (bit b of w[k] is lo + 64*k + b) and pass it to prep, if any; commit then gets
+// the windows strictly in ascending order. The worker index lets callers keep per-worker state
+// from prep to commit; w is only valid during the two calls. The first commit error stops the walk.
+func (s *shardSet) ascend(pick Pick, prep func(worker int, lo uint32, w []uint64), commit func(worker int, lo uint32, w []uint64) error) error {
+	const windows = 1 << (32 - windowBits)
+	workers := min(runtime.GOMAXPROCS(0), windows)
+	turn := make([]chan struct{}, windows+1)
+	for i := range turn {
+		turn[i] = make(chan struct{})
+	}
+	close(turn[0])
+
+	var next atomic.Int64
+	var failed atomic.Bool
+	var err error // set by the failing commit, read after wg.Wait
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for id := range workers {
+		go func() {
+			defer wg.Done()
+			w := make([]uint64, 1<<windowBits/64)
+			var tmp []uint64
+			if pick == PickSingletons {
+				tmp = make([]uint64, len(w))
+			}
+			for !failed.Load() {
+				i := int(next.Add(1) - 1)
+				if i >= windows {
+					return
+				}
+				lo := uint32(i) << windowBits
+				s.pick(pick, uint64(lo), w, tmp)
+				if prep != nil {
+					prep(id, lo, w)
+				}
+				<-turn[i] // windows are handed out in order, so the previous one is already taken
+				if !failed.Load() {
+					if cerr := commit(id, lo, w); cerr != nil {
+						err = cerr
+						failed.Store(true)
+					}
+				}
+				close(turn[i+1])
+			}
+		}()
+	}
+	wg.Wait()
+	return err
+}
+
+// pick lays out in w the addresses [lo, lo+64*len(w)) that p selects; singletons need tmp, as long as w.
+func (s *shardSet) pick(p Pick, lo uint64, w, tmp []uint64) {
+	clear(w)
+	switch p {
+	case PickDuplicates:
+		window(s.again, s.sm, lo, w)
+	case PickSingletons:
+		window(s.bits, s.sm, lo, w)
+		clear(tmp)
+		window(s.again, s.sm, lo, tmp)
+		for k := range w {
+			w[k] &^= tmp[k]
+		}
+	default:
+		window(s.bits, s.sm, lo, w)
+	}
+}
+
+// window sets in w the bits of addresses [lo, lo+64*len(w)) found in any of the shard bitsets set.
+func window(set [][]uint64, sm shardMap, lo uint64, w []uint64) {
+	S := uint64(len(set))
+	hi := lo + uint64(len(w))*64
+	if sm.rng {
+		// shards are contiguous ranges of at least 2^16 addresses: copy whole words
+		for ip := lo; ip < hi; {
+			bs := set[ip>>sm.shift]
+			off := ip & (1<<sm.shift - 1)
+			n := copy(w[(ip-lo)>>6:], bs[off>>6:])
+			ip += uint64(n) * 64
+		}
+		return
+	}
+	for id, bs := range set {
+		// shard id holds ip = off*S + id at bit off
+		first := uint64(0)
+		if lo > uint64(id) {
+			first = (lo - uint64(id) + S - 1) / S
+		}
+		end := (hi - uint64(id) + S - 1) / S
+		for k := first >> 6; k < (end+63)>>6 && k < uint64(len(bs)); k++ {
+			for word := bs[k]; word != 0; word &= word - 1 {
+				off := k<<6 | uint64(bits.TrailingZeros64(word))
+				if off < first || off >= end {
+					continue
+				}
+				ip := off*S + uint64(id) - lo
+				w[ip>>6] |= 1 << (ip & 63)
+			}
+		}
+	}
+}
+
+// collect adds the addresses of the set that pick selects to dst.
+func (s *shardSet) collect(dst *ipset.Set, pick Pick) {
+	if s.bits == nil {
+		return
+	}
+	_ = s.ascend(pick, nil, func(_ int, lo uint32, w []uint64) error {
+		dst.AddBits(lo, w)
+		return nil
+	})
+}
+
+// finish returns the unique count of a successful run, once its addresses went to cfg.Export, cfg.Set
+// and the snapshot to save if given.
+func (s *shardSet) finish() (uint64, error) {
+	if sn := s.cfg.Snapshots; sn != nil && sn.Load != nil {
+		// inputs that never started a run (empty ones, say) still carry the snapshot over
+		if err := s.ready(); err != nil {
+			return 0, err
+		}
+	}
+	if s.cfg.Export != nil {
+		if err := s.export(s.cfg.Export); err != nil {
+			return 0, err
+		}
+	}
+	if s.cfg.Set != nil {
+		s.collect(s.cfg.Set, PickAll)
+	}
+	if sn := s.cfg.Snapshots; sn != nil && sn.Save != nil {
+		if err := s.save(sn.Save, sn.Compression); err != nil {
+			return 0, err
+		}
+	}
+	return s.count(), nil
+}
@@ -0,0 +1,178 @@
+package read
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"slices"
+	"sync"
+)
+
+// Part is one of the sets a comparison of two inputs A and B splits their addresses into.
+type Part int
+
+const (
+	PartUnion Part = iota // A∪B
+	PartA                 // A
+	PartB                 // B
+	PartBoth              // A∩B
+	PartOnlyA             // A\B
+	PartOnlyB             // B\A
+)
+
+var partNames = [...]string{
+	PartUnion: "union",
+	PartA:     "a",
+	PartB:     "b",
+	PartBoth:  "both",
+	PartOnlyA: "a-b",
+	PartOnlyB: "b-a",
+}
+
+func (p Part) String() string {
+	if p >= 0 && int(p) < len(partNames) {
+		return partNames[p]
+	}
+	return fmt.Sprintf("Part(%d)", int(p))
+}
+
+// ParsePart maps a part name ("union", "a", "b", "both", "a-b", "b-a") to its Part.
+func ParsePart(s string) (Part, error) {
+	for p, name := range partNames {
+		if name == s {
+			return Part(p), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown part %q", s)
+}
+
+// Comparison is the set algebra between the unique addresses of two inputs A and B.
+type Comparison struct {
+	A, B  uint64 // |A|, |B|
+	Both  uint64 // |A∩B|
+	OnlyA uint64 // |A\B|
+	OnlyB uint64 // |B\A|
+
+	LinesA, LinesB LineStats // line accounting of either side
+	Exported       uint64    // addresses of the exported part written
+}
+
+// Union returns |A∪B|.
+func (c Comparison) Union() uint64 { return c.A + c.OnlyB }
+
+// Jaccard returns |A∩B| / |A∪B|, 1 for two empty inputs.
+func (c Comparison) Jaccard() float64 {
+	if c.Union() == 0 {
+		return 1
+	}
+	return float64(c.Both) / float64(c.Union())
+}
+
+// CompareFiles feeds the paths of a and of b into a set of shard bitsets each, like UniqueIPv4CountFilesContext,
+// and compares them shard by shard. If e is set, the addresses of part are then written to it in ascending
+// order (e.Pick is ignored), the part being worked out in place of one of the bitsets, so a comparison
+// takes two sets of bitsets whatever it exports. cfg.PerFile, Stats, Set, Export and Snapshots don't apply:
+// the Comparison carries each side's line accounting.
+func CompareFiles(ctx context.Context, a, b []string, cfg Config, part Part, e *Export) (Comparison, error) {
+	var cmp Comparison
+	if part < PartUnion || part > PartOnlyB {
+		return cmp, fmt.Errorf("unknown part %v", part)
+	}
+	cfg.PerFile, cfg.Set, cfg.Export, cfg.Snapshots = false, nil, nil, nil
+	cfg.Progress.expect(append(slices.Clip(a), b...))
+
+	cfgA, cfgB := cfg, cfg
+	cfgA.Stats, cfgB.Stats = &cmp.LinesA, &cmp.LinesB
+	sa, sb := newShardSet(ctx, cfgA), newShardSet(ctx, cfgB)
+	err := sa.compare(sb, a, b, part, e, &cmp)
+	sa.close()
+	sb.close()
+	if err != nil {
+		return Comparison{}, err
+	}
+	return cmp, nil
+}
+
+// compare reads a into s and b into o, counts the parts of both and exports part to e if set.
+func (s *shardSet) compare(o *shardSet, a, b []string, part Part, e *Export, cmp *Comparison) error {
+	if _, err := s.addFiles(a); err != nil {
+		return err
+	}
+	if _, err := o.addFiles(b); err != nil {
+		return err
+	}
+	for _, set := range []*shardSet{s, o} {
+		if set.bits == nil { // nothing was read: an empty side still takes part in the algebra
+			set.alloc()
+		}
+	}
+
+	counts := make([][3]uint64, len(s.bits))
+	var wg sync.WaitGroup
+	wg.Add(len(s.bits))
+	for id := range s.bits {
+		go func(id int) {
+			defer wg.Done()
+			var both, onlyA, onlyB uint64
+			bs := o.bits[id]
+			for k, wa := range s.bits[id] {
+				wb := bs[k]
+				both += uint64(bits.OnesCount64(wa & wb))
+				onlyA += uint64(bits.OnesCount64(wa &^ wb))
+				onlyB += uint64(bits.OnesCount64(wb &^ wa))
+			}
+			counts[id] = [3]uint64{both, onlyA, onlyB}
+		}(id)
+	}
+	wg.Wait()
+	for _, c := range counts {
+		cmp.Both += c[0]
+		cmp.OnlyA += c[1]
+		cmp.OnlyB += c[2]
+	}
+	cmp.A, cmp.B = cmp.Both+cmp.OnlyA, cmp.Both+cmp.OnlyB
+	if err := s.err(); err != nil {
+		return err
+	}
+	if e == nil {
+		return nil
+	}
+
+	// work the part out into one side's bitsets, which the export then walks as a whole
+	src := s
+	switch part {
+	case PartUnion:
+		s.combine(o, func(wa, wb uint64) uint64 { return wa | wb })
+	case PartB:
+		src = o
+	case PartBoth:
+		s.combine(o, func(wa, wb uint64) uint64 { return wa & wb })
+	case PartOnlyA:
+		s.combine(o, func(wa, wb uint64) uint64 { return wa &^ wb })
+	case PartOnlyB:
+		o.combine(s, func(wb, wa uint64) uint64 { return wb &^ wa })
+		src = o
+	}
+	e.Pick = PickAll
+	if err := src.export(e); err != nil {
+		return err
+	}
+	cmp.Exported = e.N
+	return nil
+}
+
+// combine replaces every word of s by op of it and the same word of o, shards in parallel;
+// both sets must share their layout.
+func (s *shardSet) combine(o *shardSet, op func(ws, wo uint64) uint64) {
+	var wg sync.WaitGroup
+	wg.Add(len(s.bits))
+	for id := range s.bits {
+		go func(bs, bo []uint64) {
+			defer wg.Done()
+			for k := range bs {
+				bs[k] = op(bs[k], bo[k])
+			}
+		}(s.bits[id], o.bits[id])
+	}
+	wg.Wait()
+}
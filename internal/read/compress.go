@@ -0,0 +1,284 @@
+package read
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type compression int
+
+const (
+	compNone compression = iota
+	compGzip
+	compBzip2
+	compZlib
+)
+
+func (c compression) String() string {
+	switch c {
+	case compGzip:
+		return "gzip"
+	case compBzip2:
+		return "bzip2"
+	case compZlib:
+		return "zlib"
+	default:
+		return "none"
+	}
+}
+
+// detectCompression looks at the first bytes of an input and falls back to its extension.
+// A zlib header with a preset dictionary is never accepted, which also rules out plain IPv4 text
+// (digits and '.' all have the FDICT bit set).
+func detectCompression(head []byte, name string) compression {
+	switch {
+	case len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b:
+		return compGzip
+	case len(head) >= 4 && head[0] == 'B' && head[1] == 'Z' && head[2] == 'h' && head[3] >= '1' && head[3] <= '9':
+		return compBzip2
+	case len(head) >= 2 && head[0]&0x0f == 8 && head[0]>>4 <= 7 && head[1]&0x20 == 0 &&
+		(uint16(head[0])<<8|uint16(head[1]))%31 == 0:
+		return compZlib
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".gzip", ".bgz":
+		return compGzip
+	case ".bz2", ".bzip2":
+		return compBzip2
+	case ".zz", ".zlib":
+		return compZlib
+	}
+	return compNone
+}
+
+// decompressStream wraps r with the decoder its magic bytes (or name) call for.
+func decompressStream(r io.Reader, name string) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	head, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(head) == 0 {
+		return br, nil
+	}
+	switch detectCompression(head, name) {
+	case compGzip:
+		return gzip.NewReader(br)
+	case compBzip2:
+		return bzip2.NewReader(br), nil
+	case compZlib:
+		return zlib.NewReader(br)
+	default:
+		return br, nil
+	}
+}
+
+// gzipProbe is how much a candidate member must decode cleanly (or end with a valid CRC)
+// before it's trusted as a member boundary.
+const gzipProbe = 1 << 20
+
+// gzipScan is how far past each split point a member start is looked for. Most gzip files are
+// a single member: scanning whole parts for one would read the file once more before decoding it.
+const gzipScan = 4 << 20
+
+// addGzipFile decodes a gzip file. When member boundaries can be found in the R parts of the file,
+// each reader decodes its own run of members; otherwise the file is streamed through one decoder.
+func (s *shardSet) addGzipFile(f *os.File, path string, size int64) error {
+	starts := gzipMemberStarts(f, size, s.cfg.Readers)
+	if len(starts) < 2 {
+		return s.addReader(f, path)
+	}
+	return s.addRanges(len(starts), func(i int, m meter) (io.Reader, error) {
+		hi := size
+		if i+1 < len(starts) {
+			hi = starts[i+1]
+		}
+		return newGzipRange(f, path, starts[i], hi, m)
+	})
+}
+
+// addRanges decodes n consecutive ranges of one input in parallel (open returns the decoded stream
+// of range i, metering its compressed bytes with m) and stitches the lines cut between them.
+func (s *shardSet) addRanges(n int, open func(i int, m meter) (io.Reader, error)) error {
+	meters := s.cfg.Progress.meters(n)
+	return s.run(func(outs []chan []uint32) error {
+		all := make([]edges, n)
+		errs := make([]error, n)
+		rts := make([]*router, n)
+		var wg sync.WaitGroup
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func(i int) {
+				defer wg.Done()
+				src, err := open(i, meters[i])
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				rts[i] = s.sink(outs, meters[i]).router(0)
+				all[i], errs[i] = scanDecoded(s.ctx, src, make([]byte, s.cfg.bufSize()), rts[i], i > 0)
+				rts[i].send()
+			}(i)
+		}
+		wg.Wait()
+
+		// ranges counted line offsets from their own start: shift them now that decoded sizes are known
+		var base int64
+		for i, rt := range rts {
+			if rt != nil {
+				rt.rebase(base)
+				s.lines.merge(rt)
+			}
+			base += all[i].size
+		}
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+		rt := s.sink(outs, meter{p: s.cfg.Progress}).router(0)
+		stitch(all, rt)
+		rt.flush()
+		return nil
+	})
+}
+
+// gzipMemberStarts returns offsets of gzip members to start parallel decoding from: 0 plus
+// at most one verified member start within gzipScan bytes of the start of each of the R-1 later
+// parts of the file. Parts with none are decoded by the reader before them.
+func gzipMemberStarts(ra io.ReaderAt, size int64, R int) []int64 {
+	if R < 2 {
+		return []int64{0}
+	}
+	found := make([]int64, R)
+	var wg sync.WaitGroup
+	for i := 1; i < R; i++ {
+		found[i] = -1
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lo := size * int64(i) / int64(R)
+			hi := size * int64(i+1) / int64(R)
+			found[i] = findGzipMember(ra, lo, min(hi, lo+gzipScan), size)
+		}(i)
+	}
+	wg.Wait()
+
+	return increasingStarts(found[1:])
+}
+
+// findGzipMember scans [lo, hi) for a gzip member header that decodes cleanly, -1 if none.
+func findGzipMember(ra io.ReaderAt, lo, hi, size int64) int64 {
+	const (
+		window  = 1 << 20
+		overlap = 3
+	)
+	magic := []byte{0x1f, 0x8b, 0x08}
+	buf := make([]byte, window+overlap)
+	for pos := lo; pos < hi; pos += window {
+		n, err := ra.ReadAt(buf, pos)
+		if n == 0 && err != nil {
+			return -1
+		}
+		chunk := buf[:n]
+		for i := 0; ; {
+			k := bytes.Index(chunk[i:], magic)
+			if k < 0 {
+				break
+			}
+			off := pos + int64(i+k)
+			if off >= hi {
+				return -1
+			}
+			// reserved FLG bits must be zero
+			if i+k+3 < len(chunk) && chunk[i+k+3]&0xe0 == 0 && gzipMemberAt(ra, off, size) {
+				return off
+			}
+			i += k + 1
+		}
+	}
+	return -1
+}
+
+// gzipMemberAt reports whether a member starting at off decodes for gzipProbe bytes or to a valid end.
+func gzipMemberAt(ra io.ReaderAt, off, size int64) bool {
+	zr, err := gzip.NewReader(bufio.NewReader(io.NewSectionReader(ra, off, size-off)))
+	if err != nil {
+		return false
+	}
+	zr.Multistream(false)
+	_, err = io.CopyN(io.Discard, zr, gzipProbe)
+	return err == nil || err == io.EOF
+}
+
+// gzipRange decodes the whole members found in [lo, hi) of a gzip file
+// and fails unless the last of them ends exactly at hi.
+type gzipRange struct {
+	zr     *gzip.Reader
+	cr     *countingReader
+	path   string
+	lo, hi int64
+}
+
+func newGzipRange(ra io.ReaderAt, path string, lo, hi int64, m meter) (*gzipRange, error) {
+	src := meteredReader{r: io.NewSectionReader(ra, lo, hi-lo), m: m}
+	cr := &countingReader{r: bufio.NewReaderSize(src, 1<<20)}
+	zr, err := gzip.NewReader(cr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: gzip member at offset %d: %w", path, lo, err)
+	}
+	zr.Multistream(false)
+	return &gzipRange{zr: zr, cr: cr, path: path, lo: lo, hi: hi}, nil
+}
+
+func (g *gzipRange) Read(p []byte) (int, error) {
+	for {
+		n, err := g.zr.Read(p)
+		if err != io.EOF {
+			if err != nil {
+				err = fmt.Errorf("%s: gzip member before offset %d: %w", g.path, g.lo+g.cr.n, err)
+			}
+			return n, err
+		}
+		// member done: either the range is complete or the next member follows
+		if g.lo+g.cr.n == g.hi {
+			return n, io.EOF
+		}
+		if err := g.zr.Reset(g.cr); err != nil {
+			return n, fmt.Errorf("%s: gzip member at offset %d: %w", g.path, g.lo+g.cr.n, err)
+		}
+		g.zr.Multistream(false)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// countingReader tracks the exact number of compressed bytes the decoder consumed.
+// It implements io.ByteReader so that gzip/flate don't add read-ahead buffering of their own.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
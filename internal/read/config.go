@@ -0,0 +1,92 @@
+package read
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Borislavv/ip-file-counter/internal/ipset"
+)
+
+// IOMode selects how plain-text files are read.
+type IOMode int
+
+const (
+	IOPread  IOMode = iota // parallel ReadAt into per-reader buffers
+	IOMmap                 // read-only mapping, lines parsed in place
+	IODirect               // O_DIRECT reads into aligned buffers, bypassing the page cache
+	IOURing                // io_uring with several reads in flight per reader (Linux), else pread
+)
+
+var ioModeNames = [...]string{
+	IOPread:  "pread",
+	IOMmap:   "mmap",
+	IODirect: "direct",
+	IOURing:  "uring",
+}
+
+// ErrDirectUnsupported is returned for IODirect when the platform or filesystem can't do O_DIRECT.
+var ErrDirectUnsupported = errors.New("O_DIRECT reads are not supported")
+
+func (m IOMode) String() string {
+	if m >= 0 && int(m) < len(ioModeNames) {
+		return ioModeNames[m]
+	}
+	return fmt.Sprintf("IOMode(%d)", int(m))
+}
+
+// ParseIOMode maps a -io flag value to its IOMode.
+func ParseIOMode(s string) (IOMode, error) {
+	for m, name := range ioModeNames {
+		if name == s {
+			return IOMode(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown io mode %q", s)
+}
+
+// Default block and probe sizes for zero Config.BufMB and Config.ProbeKB.
+const (
+	DefaultBufMB   = 32
+	DefaultProbeKB = 4
+)
+
+// Config tunes a counting run. Zero Shards and Readers pick GOMAXPROCS-based defaults,
+// zero BufMB and ProbeKB DefaultBufMB and DefaultProbeKB.
+type Config struct {
+	Shards   int         // aggregation shards
+	Readers  int         // parallel readers per input
+	BufMB    int         // per-reader block size in MiB
+	ProbeKB  int         // segment align probe window in KiB
+	IO       IOMode      // how plain-text files are read
+	PerFile  bool        // track per-file unique and first-seen counts (doubles bitset memory)
+	Counters Counters    // per-IP occurrence counts kept by the aggregators (IPv4FrequenciesFiles)
+	Layout   ShardLayout // how addresses are spread over shards
+
+	Retry    RetryPolicy // retries of transient read errors
+	Progress *Progress   // if set, updated with bytes and lines consumed as the run goes
+
+	Strict  bool       // fail with a *LineError on the first malformed (non-blank, non-IPv4) line
+	Samples int        // invalid lines kept in LineStats.Samples: 0 means 10, negative none
+	Stats   *LineStats // if set, filled with the line accounting once the run returns
+
+	Set    *ipset.Set // if set, every address counted is added to it once the run succeeds
+	Export *Export    // if set, the unique (or picked) addresses are written out in ascending order once the run succeeds
+
+	Snapshots *Snapshots // if set, the set is loaded from and saved to .ipset snapshots
+}
+
+func (c Config) norm() Config {
+	c.Shards = c.Layout.norm(normShards(c.Shards))
+	c.Readers = normReaders(c.Readers)
+	if c.BufMB <= 0 {
+		c.BufMB = DefaultBufMB
+	}
+	if c.ProbeKB <= 0 {
+		c.ProbeKB = DefaultProbeKB
+	}
+	return c
+}
+
+func (c Config) bufSize() int { return c.BufMB * (1 << 20) }
+
+func (c Config) probe() int64 { return int64(c.ProbeKB << 10) }
@@ -0,0 +1,139 @@
+//go:build linux
+
+package read
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// directAlign is the fallback O_DIRECT alignment when the kernel doesn't report one via statx.
+const directAlign = 4096
+
+// addSegmentsDirect reads the segments through a second O_DIRECT descriptor, bypassing the page cache.
+// Reads go to aligned buffers at aligned offsets; the bytes before a segment start are skipped.
+func (s *shardSet) addSegmentsDirect(path string, size int64, segs []segment) error {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECT|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("%s: %w", path, ErrDirectUnsupported)
+		}
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer unix.Close(fd)
+	align := directAlignment(fd)
+	meters := s.cfg.Progress.meters(len(segs))
+
+	return s.run(func(outs []chan []uint32) error {
+		errs := make([]error, len(segs))
+		var rdWG sync.WaitGroup
+		rdWG.Add(len(segs))
+		for i := range segs {
+			seg := segs[i]
+			go func(i int, lo, hi int64, last bool) {
+				defer rdWG.Done()
+				errs[i] = readSegmentDirect(s.ctx, fd, path, lo, hi, last, s.sink(outs, meters[i]), s.cfg.bufSize(), align, s.cfg.Retry)
+			}(i, seg.lo, seg.hi, seg.hi == size)
+		}
+		rdWG.Wait()
+		return errors.Join(errs...)
+	})
+}
+
+// directAlignment asks statx for the file's O_DIRECT offset and memory alignment.
+func directAlignment(fd int) int64 {
+	var st unix.Statx_t
+	if err := unix.Statx(fd, "", unix.AT_EMPTY_PATH, unix.STATX_DIOALIGN, &st); err == nil &&
+		st.Mask&unix.STATX_DIOALIGN != 0 && st.Dio_offset_align > 0 {
+		return int64(max(st.Dio_offset_align, st.Dio_mem_align))
+	}
+	return directAlign
+}
+
+// alignedBuf returns a buffer of at least size bytes (rounded up to align) whose address is aligned.
+func alignedBuf(size int, align int64) []byte {
+	a := int(align)
+	size = (size + a - 1) / a * a
+	raw := make([]byte, size+a)
+	off := 0
+	if r := int(uintptr(unsafe.Pointer(&raw[0])) % uintptr(a)); r != 0 {
+		off = a - r
+	}
+	return raw[off : off+size : off+size]
+}
+
+func readSegmentDirect(ctx context.Context, fd int, name string, lo, hi int64, isLast bool, k sink, bufSize int, align int64, retry RetryPolicy) error {
+	if hi <= lo {
+		return nil
+	}
+	buf := alignedBuf(bufSize, align)
+	rt := k.router(lo)
+	defer rt.flush()
+	var carry lineCarry
+
+	// Start at the aligned block holding lo; skip is the part of it belonging to the previous segment.
+	pos := lo &^ (align - 1)
+	skip := lo - pos
+	for pos < hi {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		want := buf
+		if rem := (hi - pos + align - 1) &^ (align - 1); int64(len(want)) > rem {
+			want = want[:rem] // still a multiple of align
+		}
+		n, err := preadFull(fd, want, pos, align, retry)
+		if err != nil {
+			return &ReadError{Path: name, Offset: pos + int64(n), Err: err}
+		}
+		if pos+int64(n) < hi && n < len(want) {
+			// the file got shorter than it was when the segments were cut
+			return &ReadError{Path: name, Offset: pos + int64(n), Err: io.ErrUnexpectedEOF}
+		}
+		end := min(int64(n), hi-pos)
+		carry.feed(rt, want[skip:end])
+		k.m.read(int(end - skip))
+		skip = 0
+		pos += int64(n)
+		if n < len(want) {
+			break // short read: end of file
+		}
+	}
+
+	// last segment may end without '\n'
+	if isLast {
+		carry.finish(rt)
+	}
+	return nil
+}
+
+// preadFull reads into p at off until it's full or the file ends, retrying on EINTR
+// and, as the policy allows, on EAGAIN. A read that stops off the alignment grid can only be the end of the file.
+func preadFull(fd int, p []byte, off, align int64, retry RetryPolicy) (int, error) {
+	n, again := 0, 0
+	for n < len(p) {
+		m, err := unix.Pread(fd, p[n:], off+int64(n))
+		if err == unix.EINTR {
+			continue
+		}
+		if err == unix.EAGAIN && retry.wait(again) {
+			again++
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n += m
+		if m == 0 || int64(m)%align != 0 {
+			break
+		}
+	}
+	return n, nil
+}
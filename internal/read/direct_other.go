@@ -0,0 +1,9 @@
+//go:build !linux
+
+package read
+
+import "fmt"
+
+func (s *shardSet) addSegmentsDirect(path string, size int64, segs []segment) error {
+	return fmt.Errorf("%s: %w", path, ErrDirectUnsupported)
+}
@@ -0,0 +1,56 @@
+package read
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+)
+
+// ReadError reports a failed read of an input at a byte offset.
+type ReadError struct {
+	Path   string
+	Offset int64
+	Err    error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("%s: read at offset %d: %v", e.Path, e.Offset, e.Err)
+}
+
+func (e *ReadError) Unwrap() error { return e.Err }
+
+// RetryPolicy bounds retries of reads that fail with a transient error (EINTR, EAGAIN).
+// The zero value never retries.
+type RetryPolicy struct {
+	Attempts int           // retries after the first failure
+	Backoff  time.Duration // pause before the first retry, doubled for every next one
+}
+
+// isTransient reports whether a read failed for a reason worth retrying.
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
+
+// readAt is ReadAt with the policy's retries, each one resuming after the bytes already read.
+func (p RetryPolicy) readAt(ra io.ReaderAt, b []byte, off int64) (int, error) {
+	n, err := ra.ReadAt(b, off)
+	for i := 0; err != nil && isTransient(err) && p.wait(i); i++ {
+		var m int
+		m, err = ra.ReadAt(b[n:], off+int64(n))
+		n += m
+	}
+	return n, err
+}
+
+// wait sleeps before retry number i (counting from 0) and reports whether it's still allowed.
+func (p RetryPolicy) wait(i int) bool {
+	if i >= p.Attempts {
+		return false
+	}
+	if p.Backoff > 0 {
+		time.Sleep(p.Backoff << i)
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package read
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"runtime"
+
+	"github.com/Borislavv/ip-file-counter/internal/codec"
+	"github.com/Borislavv/ip-file-counter/internal/ipset"
+)
+
+// Pick selects which of the unique addresses an export writes.
+type Pick int
+
+const (
+	PickAll        Pick = iota // every address seen
+	PickDuplicates             // addresses seen more than once
+	PickSingletons             // addresses seen exactly once
+)
+
+var pickNames = [...]string{
+	PickAll:        "all",
+	PickDuplicates: "duplicates",
+	PickSingletons: "singletons",
+}
+
+func (p Pick) String() string {
+	if p >= 0 && int(p) < len(pickNames) {
+		return pickNames[p]
+	}
+	return fmt.Sprintf("Pick(%d)", int(p))
+}
+
+// ParsePick maps a pick name ("all", "duplicates", "singletons") to its Pick.
+func ParsePick(s string) (Pick, error) {
+	for p, name := range pickNames {
+		if name == s {
+			return Pick(p), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown pick %q", s)
+}
+
+// Export is where a run writes its unique addresses, in ascending order, once it succeeds.
+// Picking duplicates or singletons makes the aggregators keep a second "seen again" bitset
+// per shard, doubling bitset memory.
+type Export struct {
+	W      io.Writer
+	Format codec.ListFormat
+	Pick   Pick
+	N      uint64 // addresses written, set once the export is done
+}
+
+// exportWindow is a window encoded by a worker: every address but the first, whose varint gap
+// depends on the last address of the window before.
+type exportWindow struct {
+	b           []byte
+	first, last uint32
+	n           int
+}
+
+// export encodes the windows of the set in parallel and writes them out in address order.
+func (s *shardSet) export(e *Export) error {
+	if e.Format == codec.ListRoaring {
+		return s.exportRoaring(e)
+	}
+	if s.bits == nil {
+		return nil
+	}
+	bw := bufio.NewWriterSize(e.W, 1<<20)
+	wins := make([]exportWindow, runtime.GOMAXPROCS(0))
+	var prev uint32
+	var head [16]byte
+	var n uint64
+	err := s.ascend(e.Pick, func(id int, lo uint32, w []uint64) {
+		win := &wins[id]
+		win.b, win.n = win.b[:0], 0
+		for k, word := range w {
+			for ; word != 0; word &= word - 1 {
+				ip := lo + uint32(k<<6|bits.TrailingZeros64(word))
+				if win.n > 0 {
+					win.b = e.Format.Append(win.b, ip, win.last)
+				} else {
+					win.first = ip
+				}
+				win.last = ip
+				win.n++
+			}
+		}
+	}, func(id int, _ uint32, _ []uint64) error {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		win := &wins[id]
+		if win.n == 0 {
+			return nil
+		}
+		if _, err := bw.Write(e.Format.Append(head[:0], win.first, prev)); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		if _, err := bw.Write(win.b); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		prev = win.last
+		n += uint64(win.n)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	e.N = n
+	return nil
+}
+
+// exportRoaring writes the picked addresses as a portable Roaring bitmap, gathered into a Set first:
+// the format leads with every container's key and cardinality.
+func (s *shardSet) exportRoaring(e *Export) error {
+	set := ipset.New()
+	if s.bits != nil {
+		s.collect(set, e.Pick)
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := set.WriteRoaring(e.W); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	e.N = set.Len()
+	return nil
+}
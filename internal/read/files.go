@@ -0,0 +1,157 @@
+package read
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Stdin is the input name that stands for the process standard input.
+const Stdin = "-"
+
+// FileCount is the per-input breakdown of a multi-file run.
+type FileCount struct {
+	Path   string
+	Unique uint64 // unique IPv4s within this file alone
+	Added  uint64 // uniques not seen in any earlier file of the run
+}
+
+// ExpandInputs resolves plain paths, shell-style globs and directories into a flat list of files.
+// Directories contribute their regular files (sorted by name), symlinks to regular files included;
+// with recursive set, the whole tree. Symlinks to directories aren't followed.
+// Stdin ("-") is passed through as is.
+func ExpandInputs(args []string, recursive bool) ([]string, error) {
+	var out []string
+	for _, arg := range args {
+		if arg == Stdin {
+			out = append(out, arg)
+			continue
+		}
+		matches := []string{arg}
+		if hasMeta(arg) {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("glob %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("glob %q: no matches", arg)
+			}
+		}
+		for _, p := range matches {
+			fi, err := os.Stat(p)
+			if err != nil {
+				return nil, err
+			}
+			if !fi.IsDir() {
+				out = append(out, p)
+				continue
+			}
+			files, err := dirFiles(p, recursive)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, files...)
+		}
+	}
+	return out, nil
+}
+
+func hasMeta(path string) bool {
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// dirFiles lists regular files of dir and symlinks resolving to one, descending into subdirectories
+// when recursive. Dangling symlinks are left out, like anything else that isn't a regular file.
+func dirFiles(dir string, recursive bool) ([]string, error) {
+	var out []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			fi, err := os.Stat(p)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if fi.Mode().IsRegular() {
+				out = append(out, p)
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			out = append(out, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// UniqueIPv4CountFiles feeds every path into one shared set of shard bitsets and returns the global
+// unique count. Files are processed one after another, each with cfg.Readers parallel readers;
+// Stdin is streamed. With cfg.PerFile set, it also returns each file's own unique count and how many
+// uniques it added first.
+func UniqueIPv4CountFiles(paths []string, cfg Config) (uint64, []FileCount, error) {
+	return UniqueIPv4CountFilesContext(context.Background(), paths, cfg)
+}
+
+// UniqueIPv4CountFilesContext is UniqueIPv4CountFiles that stops once ctx is done and returns ctx.Err(),
+// leaving no goroutines behind.
+func UniqueIPv4CountFilesContext(ctx context.Context, paths []string, cfg Config) (uint64, []FileCount, error) {
+	cfg.Progress.expect(paths)
+	set := newShardSet(ctx, cfg)
+	defer set.close()
+	counts, err := set.addFiles(paths)
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := set.finish()
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, counts, nil
+}
+
+// addFiles feeds paths into the set one after another, returning the per-file counts if tracking files.
+func (s *shardSet) addFiles(paths []string) ([]FileCount, error) {
+	var counts []FileCount
+	for _, path := range paths {
+		var err error
+		if path == Stdin {
+			s.lines.begin(Stdin)
+			err = s.addReader(os.Stdin, "")
+		} else {
+			err = s.addFile(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if s.cfg.PerFile {
+			own, added := s.lastRun()
+			counts = append(counts, FileCount{Path: path, Unique: own, Added: added})
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,155 @@
+package read
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// FilterFiles writes the lines of every path to w in input order, keeping the first line of each IPv4
+// as is and dropping its later repeats: awk '!seen[$0]++' over addresses, backed by one 512 MiB bitset
+// whatever the input size. Lines that aren't an IPv4 are dropped and accounted in cfg.Stats; an
+// unterminated last line gets its '\n'. Inputs are streamed (compressed ones decoded) in newline-aligned
+// blocks parsed by cfg.Readers parallel workers, then committed to the bitset and written out strictly
+// in input order. It returns the lines kept, which is the unique count. What was written before
+// a failure stays written; write errors come back wrapped with "filter:".
+func FilterFiles(ctx context.Context, paths []string, w io.Writer, cfg Config) (uint64, error) {
+	cfg.Progress.expect(paths)
+	set := newShardSet(ctx, cfg)
+	defer set.close()
+	f := &filter{set: set, bw: bufio.NewWriterSize(w, 1<<20)}
+	f.turn = sync.NewCond(&f.mu)
+	for _, path := range paths {
+		if err := f.addFile(path); err != nil {
+			return 0, err
+		}
+	}
+	if err := f.bw.Flush(); err != nil {
+		return 0, fmt.Errorf("filter: %w", err)
+	}
+	return f.kept, nil
+}
+
+// filter is the state of a FilterFiles run. The set only lends its context and line accounting:
+// its shard bitsets are never allocated.
+type filter struct {
+	set  *shardSet
+	bits []uint64 // one bit per address, allocated with the first input
+	bw   *bufio.Writer
+	kept uint64
+	werr error // first write error, which stops the run
+
+	mu   sync.Mutex
+	turn *sync.Cond
+	next int64 // the block of the current input to commit next
+
+	blocks sync.Pool // of *filterBlock
+}
+
+// filterBlock is what a worker parsed out of a block: the IPv4s in order and where their lines start.
+type filterBlock struct {
+	ips    []uint32
+	starts []uint32
+}
+
+// addFile filters one input, Stdin included.
+func (f *filter) addFile(path string) error {
+	s := f.set
+	s.lines.begin(path)
+	if err := s.err(); err != nil {
+		return err
+	}
+	r, name := io.Reader(os.Stdin), ""
+	if path != Stdin {
+		fh, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		r, name = fh, path
+	}
+	err := s.stream(r, name, func(src io.Reader, m meter) error {
+		if f.bits == nil {
+			f.bits = make([]uint64, 1<<(32-6))
+		}
+		f.next = 0
+		return streamBlocks(s.ctx, src, s.cfg.Readers, s.cfg.bufSize(), s.sink(nil, m), f.block)
+	})
+	switch {
+	case f.werr != nil:
+		return f.werr // the cancellation is just its echo
+	case s.err() != nil:
+		return s.err()
+	}
+	return err
+}
+
+// block parses a block, waits for every earlier block to be committed and then commits it.
+func (f *filter) block(rt *router, b []byte, off, seq int64) {
+	ctx := f.set.ctx
+	fb, _ := f.blocks.Get().(*filterBlock)
+	if fb == nil {
+		fb = &filterBlock{}
+	}
+	fb.ips, fb.starts = fb.ips[:0], fb.starts[:0]
+	rt.visit = func(ip uint32, lineOff int64) {
+		fb.ips = append(fb.ips, ip)
+		fb.starts = append(fb.starts, uint32(lineOff-off))
+	}
+	parseBlock(ctx)(rt, b, off, seq)
+	rt.report()
+
+	f.mu.Lock()
+	for f.next != seq {
+		f.turn.Wait()
+	}
+	f.mu.Unlock()
+	if ctx.Err() == nil {
+		if err := f.commit(b, fb); err != nil {
+			f.werr = fmt.Errorf("filter: %w", err)
+			f.set.cancel()
+		}
+	}
+	f.mu.Lock()
+	f.next++
+	f.turn.Broadcast()
+	f.mu.Unlock()
+	f.blocks.Put(fb)
+}
+
+// commit marks the addresses of a parsed block as seen and writes out the lines of the new ones,
+// runs of adjacent kept lines in one go.
+func (f *filter) commit(b []byte, fb *filterBlock) error {
+	lo, hi := 0, 0 // the run of kept lines not written yet
+	for i, ip := range fb.ips {
+		w, m := ip>>6, uint64(1)<<(ip&63)
+		if f.bits[w]&m != 0 {
+			continue
+		}
+		f.bits[w] |= m
+		f.kept++
+		start := int(fb.starts[i])
+		end := len(b)
+		if k := bytes.IndexByte(b[start:], '\n'); k >= 0 {
+			end = start + k + 1
+		}
+		if start != hi {
+			if _, err := f.bw.Write(b[lo:hi]); err != nil {
+				return err
+			}
+			lo = start
+		}
+		hi = end
+	}
+	if _, err := f.bw.Write(b[lo:hi]); err != nil {
+		return err
+	}
+	if hi > 0 && b[hi-1] != '\n' {
+		return f.bw.WriteByte('\n')
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package read
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+)
+
+// Counters selects how a run keeps per-IP occurrence counts.
+type Counters int
+
+const (
+	CountersOff    Counters = iota // no counts, just the unique bitsets
+	CountersAuto                   // a map per shard, turned into 8-bit counters once those are smaller
+	CountersSparse                 // a map per shard: small while few addresses are distinct
+	Counters8                      // a saturating uint8 per address (4 GiB in all), overflowing into a map
+	Counters16                     // a saturating uint16 per address (8 GiB in all), overflowing into a map
+)
+
+var countersNames = [...]string{
+	CountersOff:    "off",
+	CountersAuto:   "auto",
+	CountersSparse: "sparse",
+	Counters8:      "8",
+	Counters16:     "16",
+}
+
+func (c Counters) String() string {
+	if c >= 0 && int(c) < len(countersNames) {
+		return countersNames[c]
+	}
+	return fmt.Sprintf("Counters(%d)", int(c))
+}
+
+// ParseCounters maps a -counters flag value to its Counters.
+func ParseCounters(s string) (Counters, error) {
+	for c, name := range countersNames {
+		if name == s {
+			return Counters(c), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown counters %q", s)
+}
+
+// sparseEntryBytes is roughly what a map entry costs; an auto shard goes dense once its map would outgrow the counters.
+const sparseEntryBytes = 32
+
+// shardFreq holds the occurrence counts of one shard's addresses, keyed by their bit offset.
+// Dense counters saturate at their maximum; what lies beyond goes to over, so every count stays exact.
+type shardFreq struct {
+	sparse map[uint32]uint64 // sparse and not yet dense auto counts
+	c8     []uint8
+	c16    []uint16
+	over   map[uint32]uint64 // count past a saturated dense counter
+	bits   uint64            // addresses the shard covers
+	auto   bool
+}
+
+func newShardFreq(mode Counters, bits uint64) shardFreq {
+	f := shardFreq{bits: bits, auto: mode == CountersAuto}
+	switch mode {
+	case Counters8:
+		f.c8, f.over = make([]uint8, bits), make(map[uint32]uint64)
+	case Counters16:
+		f.c16, f.over = make([]uint16, bits), make(map[uint32]uint64)
+	default:
+		f.sparse = make(map[uint32]uint64)
+	}
+	return f
+}
+
+func (f *shardFreq) add(off uint64) {
+	o := uint32(off)
+	switch {
+	case f.c8 != nil:
+		if f.c8[o] < math.MaxUint8 {
+			f.c8[o]++
+		} else {
+			f.over[o]++
+		}
+	case f.c16 != nil:
+		if f.c16[o] < math.MaxUint16 {
+			f.c16[o]++
+		} else {
+			f.over[o]++
+		}
+	default:
+		f.sparse[o]++
+		if f.auto && uint64(len(f.sparse))*sparseEntryBytes > f.bits {
+			f.densify()
+		}
+	}
+}
+
+// densify turns an auto shard's map into 8-bit counters.
+func (f *shardFreq) densify() {
+	f.c8, f.over = make([]uint8, f.bits), make(map[uint32]uint64)
+	for o, n := range f.sparse {
+		if n > math.MaxUint8 {
+			f.c8[o], f.over[o] = math.MaxUint8, n-math.MaxUint8
+		} else {
+			f.c8[o] = uint8(n)
+		}
+	}
+	f.sparse = nil
+}
+
+func (f *shardFreq) count(off uint64) uint64 {
+	o := uint32(off)
+	switch {
+	case f.c8 != nil:
+		return uint64(f.c8[o]) + f.over[o]
+	case f.c16 != nil:
+		return uint64(f.c16[o]) + f.over[o]
+	default:
+		return f.sparse[o]
+	}
+}
+
+// each calls fn with the offset and count of every address seen, in no particular order for maps.
+func (f *shardFreq) each(fn func(off uint32, n uint64)) {
+	switch {
+	case f.c8 != nil:
+		for i := 0; i < len(f.c8); i += 8 {
+			if i+8 <= len(f.c8) && binary.LittleEndian.Uint64(f.c8[i:]) == 0 {
+				continue
+			}
+			for o := i; o < min(i+8, len(f.c8)); o++ {
+				if c := f.c8[o]; c != 0 {
+					fn(uint32(o), uint64(c)+f.over[uint32(o)])
+				}
+			}
+		}
+	case f.c16 != nil:
+		for o, c := range f.c16 {
+			if c != 0 {
+				fn(uint32(o), uint64(c)+f.over[uint32(o)])
+			}
+		}
+	default:
+		for o, n := range f.sparse {
+			fn(o, n)
+		}
+	}
+}
+
+// IPCount is an address with its number of occurrences.
+type IPCount struct {
+	IP    uint32
+	Count uint64
+}
+
+// before orders IPCounts by count, most frequent first, then by address.
+func (a IPCount) before(b IPCount) bool {
+	return a.Count > b.Count || a.Count == b.Count && a.IP < b.IP
+}
+
+func cmpIPCount(a, b IPCount) int {
+	switch {
+	case a.before(b):
+		return -1
+	case b.before(a):
+		return 1
+	}
+	return 0
+}
+
+// Freq holds the exact occurrence count of every address of a frequency run.
+type Freq struct {
+	sm     shardMap
+	shards []shardFreq
+	unique uint64
+}
+
+// Unique returns the number of distinct addresses.
+func (f *Freq) Unique() uint64 { return f.unique }
+
+// Count returns how many times ip occurred.
+func (f *Freq) Count(ip uint32) uint64 {
+	return f.shards[f.sm.shard(ip)].count(f.sm.bit(ip))
+}
+
+// Top returns the k most frequent addresses with their counts, most frequent first;
+// addresses with equal counts come in ascending order.
+func (f *Freq) Top(k int) []IPCount {
+	if k <= 0 {
+		return nil
+	}
+	tops := make([][]IPCount, len(f.shards))
+	var wg sync.WaitGroup
+	wg.Add(len(f.shards))
+	for id := range f.shards {
+		go func() {
+			defer wg.Done()
+			tops[id] = f.shardTop(id, k)
+		}()
+	}
+	wg.Wait()
+	all := slices.Concat(tops...)
+	slices.SortFunc(all, cmpIPCount)
+	return all[:min(k, len(all))]
+}
+
+// shardTop returns the k most frequent addresses of one shard, in order.
+func (f *Freq) shardTop(id, k int) []IPCount {
+	var top []IPCount
+	full := false // top holds the best k so far: a candidate must beat its last one
+	f.shards[id].each(func(off uint32, n uint64) {
+		c := IPCount{IP: f.sm.ip(id, uint64(off)), Count: n}
+		if full && !c.before(top[k-1]) {
+			return
+		}
+		top = append(top, c)
+		if len(top) >= 2*k {
+			slices.SortFunc(top, cmpIPCount)
+			top, full = top[:k], true
+		}
+	})
+	slices.SortFunc(top, cmpIPCount)
+	return top[:min(k, len(top))]
+}
+
+// frequencies hands the counts of a finished run over to a Freq.
+func (s *shardSet) frequencies(unique uint64) *Freq {
+	f := &Freq{sm: s.sm, shards: s.freq, unique: unique}
+	if f.shards == nil { // nothing was read
+		f.shards = make([]shardFreq, s.cfg.Shards)
+		for id := range f.shards {
+			f.shards[id] = newShardFreq(CountersSparse, 0)
+		}
+	}
+	return f
+}
+
+// IPv4FrequenciesFiles feeds every path into one set like UniqueIPv4CountFilesContext, keeping an exact
+// occurrence count per address as cfg.Counters says (CountersOff meaning CountersAuto).
+// A snapshot holds no counts, so it can't be loaded.
+func IPv4FrequenciesFiles(ctx context.Context, paths []string, cfg Config) (*Freq, []FileCount, error) {
+	if sn := cfg.Snapshots; sn != nil && sn.Load != nil {
+		return nil, nil, errors.New("frequencies can't start from a snapshot")
+	}
+	if cfg.Counters == CountersOff {
+		cfg.Counters = CountersAuto
+	}
+	cfg.Progress.expect(paths)
+	set := newShardSet(ctx, cfg)
+	defer set.close()
+	counts, err := set.addFiles(paths)
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := set.finish()
+	if err != nil {
+		return nil, nil, err
+	}
+	return set.frequencies(n), counts, nil
+}
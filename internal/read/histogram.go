@@ -0,0 +1,111 @@
+package read
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram lower bounds used when none are given.
+var DefaultBuckets = []uint64{1, 2, 3, 5, 11, 101, 1001, 10001}
+
+// Bucket is a range of occurrence counts and the addresses seen that many times.
+type Bucket struct {
+	Min         uint64 `json:"min"`
+	Max         uint64 `json:"max,omitempty"` // 0 for no upper bound
+	IPs         uint64 `json:"ips"`           // addresses seen Min..Max times
+	Occurrences uint64 `json:"occurrences"`   // lines they make up
+}
+
+// Histogram is the distribution of per-address occurrence counts.
+type Histogram struct {
+	Buckets    []Bucket `json:"buckets"`
+	Singletons uint64   `json:"singletons"` // addresses seen exactly once
+	Repeaters  uint64   `json:"repeaters"`  // addresses seen more than once
+}
+
+// ParseBuckets parses comma-separated, strictly ascending bucket lower bounds starting at 1, e.g. "1,2,11,101":
+// buckets 1, 2-10, 11-100 and 101 or more.
+func ParseBuckets(s string) ([]uint64, error) {
+	var bounds []uint64
+	for _, f := range strings.Split(s, ",") {
+		b, err := strconv.ParseUint(strings.TrimSpace(f), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bucket bound %q: %w", f, err)
+		}
+		bounds = append(bounds, b)
+	}
+	if err := checkBuckets(bounds); err != nil {
+		return nil, err
+	}
+	return bounds, nil
+}
+
+func checkBuckets(bounds []uint64) error {
+	if len(bounds) == 0 || bounds[0] != 1 {
+		return errors.New("bucket bounds must start at 1")
+	}
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			return fmt.Errorf("bucket bounds must ascend: %d after %d", bounds[i], bounds[i-1])
+		}
+	}
+	return nil
+}
+
+// Histogram sorts every address into the bucket of its occurrence count; bounds are the buckets'
+// lower bounds, strictly ascending from 1 (nil means DefaultBuckets). Shards are walked in parallel.
+func (f *Freq) Histogram(bounds []uint64) (Histogram, error) {
+	if bounds == nil {
+		bounds = DefaultBuckets
+	}
+	if err := checkBuckets(bounds); err != nil {
+		return Histogram{}, err
+	}
+	type part struct {
+		buckets []Bucket
+		ones    uint64
+	}
+	parts := make([]part, len(f.shards))
+	var wg sync.WaitGroup
+	wg.Add(len(f.shards))
+	for id := range f.shards {
+		go func() {
+			defer wg.Done()
+			p := part{buckets: make([]Bucket, len(bounds))}
+			f.shards[id].each(func(_ uint32, n uint64) {
+				i := 0
+				if n == 1 {
+					p.ones++ // most addresses are singletons: spare them the search
+				} else {
+					i, _ = slices.BinarySearch(bounds, n+1)
+					i--
+				}
+				p.buckets[i].IPs++
+				p.buckets[i].Occurrences += n
+			})
+			parts[id] = p
+		}()
+	}
+	wg.Wait()
+
+	h := Histogram{Buckets: make([]Bucket, len(bounds))}
+	for i, lo := range bounds {
+		h.Buckets[i].Min = lo
+		if i+1 < len(bounds) {
+			h.Buckets[i].Max = bounds[i+1] - 1
+		}
+		for _, p := range parts {
+			h.Buckets[i].IPs += p.buckets[i].IPs
+			h.Buckets[i].Occurrences += p.buckets[i].Occurrences
+		}
+	}
+	for _, p := range parts {
+		h.Singletons += p.ones
+	}
+	h.Repeaters = f.unique - h.Singletons
+	return h, nil
+}
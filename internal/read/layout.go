@@ -0,0 +1,81 @@
+package read
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// ShardLayout selects which shard bitset an address lives in.
+type ShardLayout int
+
+const (
+	LayoutModulo ShardLayout = iota // shard ip%S at bit ip/S: neighbours spread over every shard, even load whatever the traffic
+	LayoutRange                     // shard ip>>k at bit ip&(2^k-1): every shard a contiguous range; S rounded up to a power of two
+)
+
+var shardLayoutNames = [...]string{
+	LayoutModulo: "modulo",
+	LayoutRange:  "range",
+}
+
+func (l ShardLayout) String() string {
+	if l >= 0 && int(l) < len(shardLayoutNames) {
+		return shardLayoutNames[l]
+	}
+	return fmt.Sprintf("ShardLayout(%d)", int(l))
+}
+
+// ParseShardLayout maps a -layout flag value to its ShardLayout.
+func ParseShardLayout(s string) (ShardLayout, error) {
+	for l, name := range shardLayoutNames {
+		if name == s {
+			return ShardLayout(l), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown shard layout %q", s)
+}
+
+// norm returns the shard count the layout runs with: a power of two at least S for LayoutRange.
+func (l ShardLayout) norm(S int) int {
+	if l != LayoutRange || S <= 1 {
+		return S
+	}
+	return 1 << bits.Len(uint(S-1))
+}
+
+// shardMap places addresses into S shards following a layout.
+type shardMap struct {
+	n     uint32 // shards
+	rng   bool   // range layout
+	shift uint   // range layout: ip>>shift is the shard
+}
+
+func newShardMap(l ShardLayout, S int) shardMap {
+	if l == LayoutRange {
+		return shardMap{n: uint32(S), rng: true, shift: uint(32 - bits.TrailingZeros(uint(S)))}
+	}
+	return shardMap{n: uint32(S)}
+}
+
+func (m shardMap) shard(ip uint32) uint32 {
+	if m.rng {
+		return uint32(uint64(ip) >> m.shift)
+	}
+	return ip % m.n
+}
+
+// bit is the offset of ip in its shard's bitset.
+func (m shardMap) bit(ip uint32) uint64 {
+	if m.rng {
+		return uint64(ip) & (1<<m.shift - 1)
+	}
+	return uint64(ip / m.n)
+}
+
+// ip is the address at bit off of shard id.
+func (m shardMap) ip(id int, off uint64) uint32 {
+	if m.rng {
+		return uint32(uint64(id)<<m.shift | off)
+	}
+	return uint32(off*uint64(m.n) + uint64(id))
+}
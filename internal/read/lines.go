@@ -0,0 +1,125 @@
+package read
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// defaultSamples is how many invalid lines a run keeps when Config.Samples is zero.
+const defaultSamples = 10
+
+// maxSampleLine caps the bytes of an invalid line kept in a sample.
+const maxSampleLine = 64
+
+// maxLineLen is the longest line that can hold an IPv4 ("255.255.255.255", line ending stripped).
+const maxLineLen = 15
+
+// LineStats is the line accounting of a run. Lines = Valid + Invalid + Blank.
+type LineStats struct {
+	Lines   uint64 // every line, including an unterminated last one
+	Valid   uint64 // parsed as an IPv4
+	Invalid uint64 // rejected by the parser
+	Blank   uint64 // empty or a lone '\r'
+	Long    uint64 // longer than any IPv4 can be; a subset of Invalid
+
+	Samples []InvalidLine // the earliest invalid lines in input order, at most Config.Samples
+}
+
+func (s *LineStats) add(o LineStats) {
+	s.Lines += o.Lines
+	s.Valid += o.Valid
+	s.Invalid += o.Invalid
+	s.Blank += o.Blank
+	s.Long += o.Long
+}
+
+// InvalidLine is a line the parser rejected.
+type InvalidLine struct {
+	Path   string // input name, "-" for stdin
+	Offset int64  // byte offset of the line start; in the decoded stream for compressed inputs
+	Line   string // without its line ending, cut to 64 bytes (long lines may be cut shorter when read)
+}
+
+// LineError is returned by a strict run for the first malformed line it found.
+// With several readers that's the earliest one among those seen before the readers stopped.
+type LineError struct {
+	InvalidLine
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("%s: malformed line at offset %d: %q", e.Path, e.Offset, e.Line)
+}
+
+// lineTally merges the line accounting of every router of a run. In strict mode the first malformed
+// line stops the run through stop.
+type lineTally struct {
+	strict  bool
+	samples int
+	stop    context.CancelFunc
+
+	mu     sync.Mutex
+	path   string // current input
+	cur    int    // where the samples of the current input start
+	stats  LineStats
+	strErr *LineError
+}
+
+func newLineTally(cfg Config, stop context.CancelFunc) *lineTally {
+	n := cfg.Samples
+	if n == 0 {
+		n = defaultSamples
+	}
+	return &lineTally{strict: cfg.Strict, samples: max(n, 0), stop: stop}
+}
+
+// begin starts the accounting of the next input.
+func (t *lineTally) begin(path string) {
+	if path == "" {
+		path = Stdin
+	}
+	t.mu.Lock()
+	t.path, t.cur = path, len(t.stats.Samples)
+	t.mu.Unlock()
+}
+
+// merge adds what a router has tallied, its offsets already absolute.
+func (t *lineTally) merge(r *router) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.add(r.stats)
+	if room := t.samples - t.cur; room > 0 && len(r.bad) > 0 {
+		for i := range r.bad {
+			r.bad[i].Path = t.path
+		}
+		all := append(t.stats.Samples, r.bad...)
+		cur := all[t.cur:]
+		sort.Slice(cur, func(i, j int) bool { return cur[i].Offset < cur[j].Offset })
+		t.stats.Samples = all[:t.cur+min(len(cur), room)]
+	}
+	if e := r.strict; e != nil && (t.strErr == nil || e.Offset < t.strErr.Offset) {
+		e.Path = t.path
+		t.strErr = e
+	}
+	r.stats, r.bad, r.strict = LineStats{}, nil, nil
+}
+
+// result returns the accounting so far.
+func (t *lineTally) result() LineStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stats
+	s.Samples = append([]InvalidLine(nil), s.Samples...)
+	return s
+}
+
+// err returns the strict-mode failure, if any.
+func (t *lineTally) err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.strErr == nil {
+		return nil
+	}
+	return t.strErr
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package read
+
+import (
+	"errors"
+	"os"
+)
+
+func (s *shardSet) addSegmentsMmap(f *os.File, size int64, segs []segment) error {
+	return errors.New("mmap io is not supported on this platform")
+}
@@ -0,0 +1,99 @@
+//go:build unix
+
+package read
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// addSegmentsMmap maps the file read-only and parses every segment straight from the mapping,
+// with no per-reader buffers. Each reader advises the kernel its segment is read sequentially
+// and asks for the next window ahead of parsing the current one.
+func (s *shardSet) addSegmentsMmap(f *os.File, size int64, segs []segment) error {
+	if size == 0 {
+		// nothing to map, but the run still happens, resetting the per-file counts of the previous one
+		return s.run(func([]chan []uint32) error { return nil })
+	}
+	if size > math.MaxInt {
+		return fmt.Errorf("mmap: file of %d bytes doesn't fit the address space", size)
+	}
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("mmap: %w", err)
+	}
+	defer unix.Munmap(data)
+
+	window := s.cfg.bufSize()
+	meters := s.cfg.Progress.meters(len(segs))
+	return s.run(func(outs []chan []uint32) error {
+		var rdWG sync.WaitGroup
+		rdWG.Add(len(segs))
+		for i := range segs {
+			seg := segs[i]
+			go func(i, lo, hi int, last bool) {
+				defer rdWG.Done()
+				readSegmentMmap(s.ctx, data, lo, hi, last, s.sink(outs, meters[i]), window)
+			}(i, int(seg.lo), int(seg.hi), seg.hi == size)
+		}
+		rdWG.Wait()
+		return nil
+	})
+}
+
+func readSegmentMmap(ctx context.Context, data []byte, lo, hi int, isLast bool, k sink, window int) {
+	if hi <= lo {
+		return
+	}
+	if window < 1 {
+		window = hi - lo
+	}
+	page := unix.Getpagesize()
+	advise := func(from, to int, advice int) {
+		from -= from % page // madvise wants a page-aligned start
+		_ = unix.Madvise(data[from:to], advice)
+	}
+	advise(lo, hi, unix.MADV_SEQUENTIAL)
+
+	rt := k.router(int64(lo))
+	pos, seen := lo, lo // seen: end of the bytes metered so far
+	for pos < hi {
+		if ctx.Err() != nil {
+			break // run reports the cancellation
+		}
+		end := min(pos+window, hi)
+		if end < hi {
+			advise(end, min(end+window, hi), unix.MADV_WILLNEED)
+		}
+		tail := rt.lines(data[pos:end])
+		k.m.read(end - seen)
+		seen = end
+		pos = end - len(tail)
+		if end == hi {
+			break
+		}
+		if pos == end-window {
+			// A single line fills the whole window: it can't be an IPv4, report and skip it.
+			rt.longLine(data[pos:min(pos+maxSampleLine, end)])
+			nl := bytes.IndexByte(data[end:hi], '\n')
+			if nl < 0 {
+				pos = hi
+				break
+			}
+			pos = end + nl + 1
+			rt.off = int64(pos)
+		}
+	}
+
+	// last segment may end without '\n'
+	if isLast && pos < hi && ctx.Err() == nil {
+		rt.line(data[pos:hi])
+	}
+	rt.flush()
+}
@@ -0,0 +1,227 @@
+package read
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+	"sync"
+)
+
+// OverlapFile is one input of an overlap matrix.
+type OverlapFile struct {
+	Path      string    `json:"path"`
+	Unique    uint64    `json:"unique"`    // distinct addresses of the file
+	Exclusive uint64    `json:"exclusive"` // of them, those found in no other file
+	Lines     LineStats `json:"-"`
+}
+
+// Overlap is the pairwise overlap of the unique addresses of N inputs.
+type Overlap struct {
+	Files  []OverlapFile `json:"files"`
+	Matrix [][]uint64    `json:"matrix"` // Matrix[i][j] is |F_i ∩ F_j|; the diagonal is each file's Unique
+	Passes int           `json:"passes"` // how many times every input was read to stay within the budget
+}
+
+// ErrOverlapStdin is returned for an overlap of stdin, which can't be read more than once.
+var ErrOverlapStdin = errors.New("overlap: stdin can't be read more than once")
+
+// OverlapFiles counts every path into a bitset of its own and returns the pairwise intersections and
+// each file's count of addresses found in no other file. At most resident bitsets (a set of shard bitsets,
+// 512 MiB, each) are allocated at once: if the N files fit, each is read once; else the files are taken
+// resident-2 at a time, every other file being streamed through one more bitset past them while
+// a last one ORs what it streamed, so each pass reads every input once. cfg.PerFile, Stats, Set, Export
+// and Snapshots don't apply: each OverlapFile carries its line accounting, tallied on the first pass.
+func OverlapFiles(ctx context.Context, paths []string, cfg Config, resident int) (*Overlap, error) {
+	N := len(paths)
+	if slices.Contains(paths, Stdin) {
+		return nil, ErrOverlapStdin
+	}
+	block := N
+	if N > resident {
+		block = resident - 2
+	}
+	if block < 1 {
+		return nil, fmt.Errorf("overlap: a budget of %d bitsets can't compare %d files: it takes at least 3", resident, N)
+	}
+	cfg.PerFile, cfg.Set, cfg.Export, cfg.Snapshots = false, nil, nil, nil
+	cfg = cfg.norm()
+	passes := (N + block - 1) / block
+	cfg.Progress.expect(slices.Repeat(paths, passes)) // every pass reads every input
+
+	o := &overlap{ctx: ctx, cfg: cfg, paths: paths, res: &Overlap{Files: make([]OverlapFile, N), Matrix: make([][]uint64, N), Passes: passes}}
+	for i, path := range paths {
+		o.res.Files[i].Path = path
+		o.res.Matrix[i] = make([]uint64, N)
+	}
+	o.slots = make([]*bitset, min(block, N))
+	for x := range o.slots {
+		o.slots[x] = &bitset{bits: shardBits(cfg.Shards)}
+	}
+	if passes > 1 {
+		o.stream, o.others = &bitset{bits: shardBits(cfg.Shards)}, &bitset{bits: shardBits(cfg.Shards)}
+	}
+	for lo := 0; lo < N; lo += block {
+		if err := o.pass(lo, min(lo+block, N), lo == 0); err != nil {
+			return nil, err
+		}
+	}
+	return o.res, nil
+}
+
+// overlap is the state of an OverlapFiles run.
+type overlap struct {
+	ctx   context.Context
+	cfg   Config // normalized
+	paths []string
+	res   *Overlap
+
+	slots  []*bitset // the resident files of the pass
+	stream *bitset   // the file streamed past them, nil when every file is resident
+	others *bitset   // the union of the streamed files
+}
+
+// bitset is a set of shard bitsets reused from file to file.
+type bitset struct {
+	bits  [][]uint64
+	dirty bool // fresh bitsets are zero already: clearing them would only fault their pages in
+}
+
+// reset clears b for its next use.
+func (b *bitset) reset() {
+	if b.dirty {
+		for _, bs := range b.bits {
+			clear(bs)
+		}
+	}
+	b.dirty = true
+}
+
+// read counts path i into b, tallying its lines if first is set.
+func (o *overlap) read(i int, b *bitset, first bool) error {
+	cfg := o.cfg
+	cfg.Stats = nil
+	if first {
+		cfg.Stats = &o.res.Files[i].Lines
+	}
+	s := newShardSet(o.ctx, cfg)
+	defer s.close()
+	b.reset()
+	s.bits = b.bits
+	_, err := s.addFiles(o.paths[i : i+1])
+	return err
+}
+
+// pass makes files [lo, hi) resident, streams every other file past them and fills in their rows:
+// intersections with the streamed files and with each other, and their exclusive counts.
+func (o *overlap) pass(lo, hi int, first bool) error {
+	k := hi - lo
+	for x := range k {
+		if err := o.read(lo+x, o.slots[x], first); err != nil {
+			return err
+		}
+	}
+	if o.stream != nil {
+		o.others.reset()
+		for j := range o.paths {
+			if j >= lo && j < hi {
+				continue
+			}
+			if err := o.read(j, o.stream, first); err != nil {
+				return err
+			}
+			inter := o.shards(func(id int, acc []uint64) {
+				st, ot, rows := o.stream.bits[id], o.others.bits[id], o.rows(id, k)
+				for w, sw := range st {
+					if sw == 0 {
+						continue
+					}
+					for x, row := range rows {
+						acc[x] += uint64(bits.OnesCount64(row[w] & sw))
+					}
+					ot[w] |= sw
+				}
+			}, k)
+			for x := range k {
+				o.res.Matrix[lo+x][j] = inter[x]
+				o.res.Matrix[j][lo+x] = inter[x]
+			}
+		}
+	}
+
+	// acc holds the k*k intersections among the resident files, then their k exclusive counts
+	acc := o.shards(func(id int, acc []uint64) {
+		var ot []uint64
+		if o.others != nil {
+			ot = o.others.bits[id]
+		}
+		rows := o.rows(id, k)
+		words := make([]uint64, k)
+		before := make([]uint64, k) // the OR of the other files and the resident ones before x
+		for w := range rows[0] {
+			var or, set uint64
+			if ot != nil {
+				or = ot[w]
+			}
+			for x, row := range rows {
+				words[x] = row[w]
+				before[x] = or
+				or |= words[x]
+				set |= words[x]
+			}
+			if set == 0 {
+				continue
+			}
+			var after uint64 // the OR of the resident files after x
+			for x := k - 1; x >= 0; x-- {
+				acc[k*k+x] += uint64(bits.OnesCount64(words[x] &^ (before[x] | after)))
+				after |= words[x]
+				for y := x; y < k; y++ {
+					acc[x*k+y] += uint64(bits.OnesCount64(words[x] & words[y]))
+				}
+			}
+		}
+	}, k*k+k)
+	for x := range k {
+		for y := x; y < k; y++ {
+			o.res.Matrix[lo+x][lo+y] = acc[x*k+y]
+			o.res.Matrix[lo+y][lo+x] = acc[x*k+y]
+		}
+		o.res.Files[lo+x].Unique = acc[x*k+x]
+		o.res.Files[lo+x].Exclusive = acc[k*k+x]
+	}
+	return o.ctx.Err()
+}
+
+// rows returns shard id of the k files resident.
+func (o *overlap) rows(id, k int) [][]uint64 {
+	rows := make([][]uint64, k)
+	for x := range rows {
+		rows[x] = o.slots[x].bits[id]
+	}
+	return rows
+}
+
+// shards runs fn over every shard in parallel, each with n counters of its own, and returns their sums.
+func (o *overlap) shards(fn func(id int, acc []uint64), n int) []uint64 {
+	S := o.cfg.Shards
+	accs := make([][]uint64, S)
+	var wg sync.WaitGroup
+	wg.Add(S)
+	for id := range S {
+		go func() {
+			defer wg.Done()
+			accs[id] = make([]uint64, n)
+			fn(id, accs[id])
+		}()
+	}
+	wg.Wait()
+	sum := make([]uint64, n)
+	for _, acc := range accs {
+		for x, v := range acc {
+			sum[x] += v
+		}
+	}
+	return sum
+}
@@ -0,0 +1,164 @@
+package read
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress accumulates what a run has consumed so far. Readers update it with atomics while they go;
+// any goroutine may take a Snapshot at any time. The zero value is ready to use: set it in
+// Config.Progress and poll it from elsewhere. One Progress serves one run.
+type Progress struct {
+	bytes atomic.Int64
+	lines atomic.Int64
+	total atomic.Int64
+
+	mu       sync.Mutex
+	start    time.Time
+	segs     []atomic.Int64 // bytes consumed by each segment of the current input
+	lastAt   time.Time      // previous snapshot, for the current rate
+	lastSeen int64
+}
+
+// ProgressSnapshot is a point-in-time view of a Progress.
+type ProgressSnapshot struct {
+	Bytes    int64         // input bytes consumed (compressed bytes for compressed inputs)
+	Total    int64         // input bytes the run will consume, 0 if unknown (stdin, pipes)
+	Lines    int64         // lines parsed
+	Segments []int64       // bytes consumed by each segment of the current input
+	Elapsed  time.Duration // since the run started
+	Rate     float64       // bytes per second since the previous snapshot (the first one: since the start)
+}
+
+// Percent returns how much of Total is done, false when Total is unknown.
+func (s ProgressSnapshot) Percent() (float64, bool) {
+	if s.Total <= 0 {
+		return 0, false
+	}
+	return min(100*float64(s.Bytes)/float64(s.Total), 100), true
+}
+
+// ETA estimates the time left from the average rate so far, false when it can't be told yet.
+func (s ProgressSnapshot) ETA() (time.Duration, bool) {
+	if s.Total <= 0 || s.Bytes <= 0 || s.Elapsed <= 0 {
+		return 0, false
+	}
+	left := float64(max(s.Total-s.Bytes, 0))
+	return time.Duration(left / float64(s.Bytes) * float64(s.Elapsed)), true
+}
+
+// Snapshot returns the counters as of now.
+func (p *Progress) Snapshot() ProgressSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	s := ProgressSnapshot{
+		Bytes: p.bytes.Load(),
+		Total: p.total.Load(),
+		Lines: p.lines.Load(),
+	}
+	if !p.start.IsZero() {
+		s.Elapsed = now.Sub(p.start)
+	}
+	s.Segments = make([]int64, len(p.segs))
+	for i := range p.segs {
+		s.Segments[i] = p.segs[i].Load()
+	}
+
+	from, seen := p.lastAt, p.lastSeen
+	if from.IsZero() {
+		from, seen = p.start, 0
+	}
+	if dt := now.Sub(from).Seconds(); !from.IsZero() && dt > 0 {
+		s.Rate = float64(s.Bytes-seen) / dt
+	}
+	p.lastAt, p.lastSeen = now, s.Bytes
+	return s
+}
+
+// begin starts the clock and records the expected input size of the whole run (0 if unknown).
+func (p *Progress) begin(total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+	p.mu.Unlock()
+	p.total.Store(total)
+}
+
+// expect begins a run over paths, summing their sizes. Stdin or any input that isn't a regular file
+// makes the total unknown.
+func (p *Progress) expect(paths []string) {
+	if p == nil {
+		return
+	}
+	var total int64
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if path == Stdin || err != nil || !fi.Mode().IsRegular() {
+			total = 0
+			break
+		}
+		total += fi.Size()
+	}
+	p.begin(total)
+}
+
+// meters starts a new input read as n segments and returns one meter per segment.
+func (p *Progress) meters(n int) []meter {
+	out := make([]meter, n)
+	if p == nil {
+		return out
+	}
+	p.mu.Lock()
+	p.segs = make([]atomic.Int64, n)
+	for i := range out {
+		out[i] = meter{p: p, seg: &p.segs[i]}
+	}
+	p.mu.Unlock()
+	return out
+}
+
+// meter is one reader's handle on a Progress. The zero meter counts nothing;
+// one without a segment only counts toward the totals.
+type meter struct {
+	p   *Progress
+	seg *atomic.Int64
+}
+
+// read records n input bytes consumed by the meter's segment.
+func (m meter) read(n int) {
+	if m.p == nil || n <= 0 {
+		return
+	}
+	if m.seg != nil {
+		m.seg.Add(int64(n))
+	}
+	m.p.bytes.Add(int64(n))
+}
+
+// parsed records n lines parsed.
+func (m meter) parsed(n int64) {
+	if m.p != nil && n > 0 {
+		m.p.lines.Add(n)
+	}
+}
+
+// meteredReader counts the bytes read from a sequential input.
+type meteredReader struct {
+	r io.Reader
+	m meter
+}
+
+func (r meteredReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.m.read(n)
+	return n, err
+}
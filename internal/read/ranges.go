@@ -0,0 +1,141 @@
+package read
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// maxFragment caps a line fragment kept at a decoded range edge. Anything longer can't be an IPv4.
+const maxFragment = maxSampleLine
+
+// fragment is a partial line cut by a range edge.
+type fragment struct {
+	b    []byte
+	long bool // more than maxFragment bytes were offered: b is only the head of a line that is not an IPv4
+}
+
+func (f *fragment) append(p []byte) {
+	if f.long {
+		return
+	}
+	if room := maxFragment - len(f.b); len(p) > room {
+		f.b, f.long = append(f.b, p[:room]...), true
+		return
+	}
+	f.b = append(f.b, p...)
+}
+
+func (f *fragment) join(g fragment) {
+	f.append(g.b)
+	f.long = f.long || g.long
+}
+
+func (f *fragment) parse(rt *router) {
+	switch {
+	case f.long:
+		rt.longLine(f.b)
+	case len(f.b) > 0:
+		rt.line(f.b)
+	}
+}
+
+// edges is what a decoder of one range of a compressed file leaves unparsed: the bytes before its
+// first '\n' and after its last one. Neighbouring edges are stitched once every range is done.
+type edges struct {
+	head, tail fragment
+	whole      bool  // no '\n' in the range at all: everything is in head
+	tailAt     int64 // offset of the tail in the decoded range
+	size       int64 // decoded bytes of the range
+}
+
+// stitch parses the lines cut between consecutive ranges, including the final unterminated line.
+// The first range starts on a line start, so its head was parsed in place.
+func stitch(all []edges, rt *router) {
+	var (
+		cur  fragment
+		base int64 // decoded offset of the current range
+	)
+	for i, e := range all {
+		switch {
+		case i == 0:
+			cur, rt.off = e.tail, e.tailAt
+		case e.whole:
+			cur.join(e.head)
+		default:
+			cur.join(e.head)
+			cur.parse(rt)
+			cur, rt.off = e.tail, base+e.tailAt
+		}
+		base += e.size
+	}
+	cur.parse(rt)
+}
+
+// scanDecoded parses the decoded stream of one range with buf as the block buffer.
+// With keepHead set the range may start mid-line, so everything before the first '\n' is returned
+// as the head edge; the unterminated end of the stream is always returned as the tail edge.
+// Line offsets given to rt count from the start of the range.
+func scanDecoded(ctx context.Context, src io.Reader, buf []byte, rt *router, keepHead bool) (edges, error) {
+	var (
+		e        edges
+		carryLen int
+		inHead   = keepHead
+		skipping bool     // dropping the rest of a line longer than buf
+		long     fragment // head of the line being dropped
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return e, err
+		}
+		n, er := fill(src, buf[carryLen:])
+		data := buf[:carryLen+n]
+		start := e.size - int64(carryLen) // decoded offset of data[0]
+		e.size += int64(n)
+		carryLen = 0
+
+		if inHead {
+			k := bytes.IndexByte(data, '\n')
+			if k < 0 {
+				e.head.append(data)
+				data = data[:0]
+			} else {
+				e.head.append(data[:k])
+				data = data[k+1:]
+				rt.off = start + int64(k+1)
+				inHead = false
+			}
+		} else if skipping {
+			if k := bytes.IndexByte(data, '\n'); k >= 0 {
+				rt.longLine(long.b)
+				data = data[k+1:]
+				rt.off = start + int64(k+1)
+				skipping, long = false, fragment{}
+			} else {
+				data = data[:0]
+			}
+		}
+
+		tail := rt.lines(data)
+		if er != nil {
+			if er != io.EOF {
+				return e, er
+			}
+			if skipping {
+				e.tail = long // the next range may still hold the rest of it
+			} else {
+				e.tail.append(tail)
+			}
+			e.whole = inHead
+			e.tailAt = rt.off
+			return e, nil
+		}
+		if len(tail) == len(buf) {
+			// A single line fills the whole block: it can't be an IPv4, drop it once its end is found.
+			long.append(tail)
+			skipping = true
+			continue
+		}
+		carryLen = copy(buf, tail)
+	}
+}
@@ -2,6 +2,8 @@ package read
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"math/bits"
 	"os"
@@ -10,6 +12,123 @@ import (
 )
 
 func UniqueIPv4Count(path string, shards, readers, bufMb, probeKb int) (uint64, error) {
+	cfg := Config{Shards: shards, Readers: readers, BufMB: bufMb, ProbeKB: probeKb}
+	return UniqueIPv4CountContext(context.Background(), path, cfg)
+}
+
+// UniqueIPv4CountContext is UniqueIPv4Count that stops reading and aggregating once ctx is done
+// and returns ctx.Err(). No goroutine it starts outlives the call, whatever the outcome,
+// and the shard bitsets are only allocated once the input is open.
+func UniqueIPv4CountContext(ctx context.Context, path string, cfg Config) (uint64, error) {
+	cfg.Progress.expect([]string{path})
+	set := newShardSet(ctx, cfg)
+	defer set.close()
+	if err := set.addFile(path); err != nil {
+		return 0, err
+	}
+	return set.finish()
+}
+
+// UniqueIPv4CountReaderAt counts unique IPv4s in the first size bytes of plain text behind ra,
+// read by cfg.Readers parallel ReadAt loops. The name only labels errors.
+func UniqueIPv4CountReaderAt(ra io.ReaderAt, size int64, name string, cfg Config) (uint64, error) {
+	cfg.Progress.begin(size)
+	set := newShardSet(context.Background(), cfg)
+	defer set.close()
+	set.lines.begin(name)
+	segs, err := set.segments(ra, name, size)
+	if err != nil {
+		return 0, err
+	}
+	if err := set.addSegmentsPread(ra, name, size, segs); err != nil {
+		return 0, err
+	}
+	return set.finish()
+}
+
+// addFile feeds one file into the set using R parallel readers.
+func (s *shardSet) addFile(path string) error {
+	s.lines.begin(path)
+	// Single shared file handle (ReadAt is concurrency-safe).
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		// Pipes, FIFOs and character devices can't ReadAt: stream them instead.
+		return s.addReader(f, path)
+	}
+	size := fi.Size()
+
+	var head [bgzfHeaderMax]byte
+	n, err := f.ReadAt(head[:], 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch detectCompression(head[:n], path) {
+	case compNone:
+	case compGzip:
+		if bgzfBlockSize(head[:n]) > 0 {
+			return s.addBGZFFile(f, path, size)
+		}
+		return s.addGzipFile(f, path, size)
+	default:
+		return s.addReader(f, path)
+	}
+
+	segs, err := s.segments(f, path, size)
+	if err != nil {
+		return err
+	}
+	switch s.cfg.IO {
+	case IOMmap:
+		return s.addSegmentsMmap(f, size, segs)
+	case IODirect:
+		return s.addSegmentsDirect(path, size, segs)
+	case IOURing:
+		return s.addSegmentsURing(f, size, segs)
+	}
+	return s.addSegmentsPread(f, path, size, segs)
+}
+
+// segments prepares R segments (independent from S shards), aligned to '\n' (left-only) and stitched.
+func (s *shardSet) segments(ra io.ReaderAt, name string, size int64) ([]segment, error) {
+	segs := split(size, s.cfg.Readers)
+	if err := alignSegments(ra, name, segs, s.cfg.probe(), s.cfg.Retry); err != nil {
+		return nil, err
+	}
+	return segs, nil
+}
+
+// addSegmentsPread reads every segment with its own ReadAt loop over the shared handle.
+func (s *shardSet) addSegmentsPread(ra io.ReaderAt, name string, size int64, segs []segment) error {
+	readBuf := s.cfg.bufSize()
+	meters := s.cfg.Progress.meters(len(segs))
+	return s.run(func(outs []chan []uint32) error {
+		// Parallel readers per segment.
+		errs := make([]error, len(segs))
+		var rdWG sync.WaitGroup
+		rdWG.Add(len(segs))
+		for i := range segs {
+			seg := segs[i]
+			isLast := seg.hi == size // real last by file end
+			go func(i int, lo, hi int64, last bool) {
+				defer rdWG.Done()
+				errs[i] = readSegmentReadAt(s.ctx, ra, name, lo, hi, last, s.sink(outs, meters[i]), readBuf, s.cfg.Retry)
+			}(i, seg.lo, seg.hi, isLast)
+		}
+		rdWG.Wait()
+		return errors.Join(errs...)
+	})
+}
+
+func normShards(shards int) int {
 	S := shards
 	if S <= 0 {
 		S = runtime.GOMAXPROCS(0) * 4
@@ -20,6 +139,10 @@ func UniqueIPv4Count(path string, shards, readers, bufMb, probeKb int) (uint64,
 			S = 1
 		}
 	}
+	return S
+}
+
+func normReaders(readers int) int {
 	R := readers
 	if R <= 0 {
 		R = runtime.GOMAXPROCS(0)
@@ -30,88 +153,270 @@ func UniqueIPv4Count(path string, shards, readers, bufMb, probeKb int) (uint64,
 			R = 1
 		}
 	}
-	readBuf := bufMb * (1 << 20)
-	probeThresholdKb := int64(probeKb << 10)
+	return R
+}
+
+// shardSet holds S single-owner bitsets with exact 2^32 coverage: ip lives in shard ip%S at bit ip/S,
+// or with the range layout in shard ip>>k at bit ip&(2^k-1), S being 2^(32-k).
+// With file tracking on, a second scratch bitset per shard records what the current run has seen,
+// so every run reports its own unique count and how many of them were new to the set.
+type shardSet struct {
+	ctx    context.Context // stops every run once done
+	cancel context.CancelFunc
+	cfg    Config     // normalized
+	sm     shardMap   // cfg.Layout over cfg.Shards
+	bits   [][]uint64 // allocated by the first run
+	lines  *lineTally
+
+	freq    []shardFreq // per-shard occurrence counts, nil unless cfg.Counters is on
+	loading bool        // the run feeds a snapshot in
+	again   [][]uint64  // addresses seen more than once, nil unless an export picks duplicates or singletons
+	scratch [][]uint64  // per-run bitsets, nil unless tracking files
+	own     []uint64    // per-shard uniques of the last run
+	added   []uint64    // per-shard first-time uniques of the last run
+}
+
+func newShardSet(ctx context.Context, cfg Config) *shardSet {
+	ctx, cancel := context.WithCancel(ctx)
+	cfg = cfg.norm()
+	return &shardSet{ctx: ctx, cancel: cancel, cfg: cfg, sm: newShardMap(cfg.Layout, cfg.Shards), lines: newLineTally(cfg, cancel)}
+}
+
+// close releases the set's context and hands the line accounting to cfg.Stats.
+func (s *shardSet) close() {
+	s.cancel()
+	if s.cfg.Stats != nil {
+		*s.cfg.Stats = s.lines.result()
+	}
+}
+
+// err is why the set stopped early: a malformed line in strict mode or the context being done.
+func (s *shardSet) err() error {
+	if err := s.lines.err(); err != nil {
+		return err
+	}
+	return s.ctx.Err()
+}
+
+// alloc allocates the shard bitsets, so an input that can't even be opened costs no memory.
+func (s *shardSet) alloc() {
+	S := s.cfg.Shards
+	s.bits = shardBits(S)
+	if s.cfg.Counters != CountersOff {
+		s.freq = make([]shardFreq, S)
+		for i := range s.freq {
+			s.freq[i] = newShardFreq(s.cfg.Counters, bitsPerShard(S))
+		}
+	}
+	if e := s.cfg.Export; e != nil && e.Pick != PickAll {
+		s.again = shardBits(S)
+	}
+	if s.cfg.PerFile {
+		s.scratch = shardBits(S)
+		s.own = make([]uint64, S)
+		s.added = make([]uint64, S)
+	}
+}
 
-	// Bitsets per shard: exact 2^32 coverage
+// bitsPerShard is how many addresses each of S shards covers.
+func bitsPerShard(S int) uint64 {
 	const totalBits = uint64(1) << 32
-	bitsPerShard := (totalBits + uint64(S) - 1) / uint64(S)
-	wordsPerShard := int((bitsPerShard + 63) / 64)
+	return (totalBits + uint64(S) - 1) / uint64(S)
+}
+
+// shardBits allocates S zeroed shard bitsets covering every address between them.
+func shardBits(S int) [][]uint64 {
+	wordsPerShard := int((bitsPerShard(S) + 63) / 64)
+	bs := make([][]uint64, S)
+	for i := range bs {
+		bs[i] = make([]uint64, wordsPerShard)
+	}
+	return bs
+}
+
+// ready allocates the bitsets, once, and fills them with the snapshot to start from if there's one.
+func (s *shardSet) ready() error {
+	if s.bits != nil {
+		return nil
+	}
+	s.alloc()
+	if sn := s.cfg.Snapshots; sn != nil && sn.Load != nil {
+		return s.load(sn)
+	}
+	return nil
+}
 
-	shardBits := make([][]uint64, S)
+// run starts one aggregator per shard, lets feed push batches into their inputs
+// and returns once every batch has been applied. Once the context is done, aggregators drop
+// what's still queued and run returns the context error, whatever feed returned.
+func (s *shardSet) run(feed func(outs []chan []uint32) error) error {
+	if err := s.err(); err != nil {
+		return err
+	}
+	if err := s.ready(); err != nil {
+		return err
+	}
+	S, sm := len(s.bits), s.sm
+	done := s.ctx.Done()
 	in := make([]chan []uint32, S)
-	for i := 0; i < S; i++ {
-		shardBits[i] = make([]uint64, wordsPerShard)
+	for i := range in {
 		in[i] = make(chan []uint32, 64) // deeper buffer to reduce reader stalls
 	}
 
 	// Aggregators: single-owner bitset.
 	var aggWG sync.WaitGroup
-	counts := make([]uint64, S)
 	aggWG.Add(S)
 	for id := 0; id < S; id++ {
-		id := id
-		go func() {
+		if s.scratch != nil {
+			go func(id int, in <-chan []uint32) {
+				defer aggWG.Done()
+				s.aggregateTracked(id, in, done)
+			}(id, in[id])
+			continue
+		}
+		go func(bs, again []uint64, fq *shardFreq, in <-chan []uint32) {
 			defer aggWG.Done()
-			bs := shardBits[id]
-			for batch := range in[id] {
-				for _, ip := range batch {
-					off := uint64(ip) / uint64(S)
-					w := off >> 6
-					b := off & 63
-					bs[w] |= 1 << b
+			for batch := range in {
+				if cancelled(done) {
+					putBatch(batch)
+					continue
+				}
+				if again != nil {
+					for _, ip := range batch {
+						off := sm.bit(ip)
+						w := off >> 6
+						m := uint64(1) << (off & 63)
+						again[w] |= bs[w] & m
+						bs[w] |= m
+					}
+				} else {
+					for _, ip := range batch {
+						off := sm.bit(ip)
+						w := off >> 6
+						b := off & 63
+						bs[w] |= 1 << b
+					}
+				}
+				if fq != nil {
+					for _, ip := range batch {
+						fq.add(sm.bit(ip))
+					}
 				}
 				putBatch(batch)
 			}
-			var c uint64
-			for _, w := range bs {
-				c += uint64(bits.OnesCount64(w))
-			}
-			counts[id] = c
-		}()
+		}(s.bits[id], s.shardAgain(id), s.shardFreq(id), in[id])
 	}
 
-	// Single shared file handle (ReadAt is concurrency-safe).
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, err
+	err := feed(in)
+	for i := 0; i < S; i++ {
+		close(in[i])
 	}
-	defer f.Close()
+	aggWG.Wait()
+	if cerr := s.err(); cerr != nil {
+		return cerr // reader errors past cancellation are just its echo
+	}
+	return err
+}
 
-	fi, err := f.Stat()
-	if err != nil {
-		return 0, err
+// shardFreq returns the occurrence counts of shard id, nil unless counting them.
+// A loaded snapshot has no counts: nothing is counted while loading it.
+func (s *shardSet) shardFreq(id int) *shardFreq {
+	if s.freq == nil || s.loading {
+		return nil
 	}
-	size := fi.Size()
+	return &s.freq[id]
+}
 
-	// Prepare R segments (independent from S shards) + align to '\n' (left-only) and stitch.
-	segs := split(size, R)
-	if err := alignSegments(f, segs, probeThresholdKb); err != nil {
-		return 0, err
+// shardAgain returns the "seen again" bitset of shard id, nil unless tracking repeats.
+func (s *shardSet) shardAgain(id int) []uint64 {
+	if s.again == nil {
+		return nil
 	}
+	return s.again[id]
+}
 
-	// Parallel readers per segment.
-	var rdWG sync.WaitGroup
-	rdWG.Add(len(segs))
-	for i := range segs {
-		seg := segs[i]
-		isLast := seg.hi == size // real last by file end
-		go func(lo, hi int64, last bool) {
-			defer rdWG.Done()
-			readSegmentReadAt(f, lo, hi, last, in, readBuf)
-		}(seg.lo, seg.hi, isLast)
+// cancelled reports whether done is closed without blocking.
+func cancelled(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
 	}
-	rdWG.Wait()
-	for i := 0; i < S; i++ {
-		close(in[i])
+}
+
+// aggregateTracked is the file-tracking aggregator: it sets bits in both the run scratch
+// and the global bitset, counting first-time bits of each.
+func (s *shardSet) aggregateTracked(id int, in <-chan []uint32, done <-chan struct{}) {
+	sm, fq := s.sm, s.shardFreq(id)
+	bs, seen, again := s.bits[id], s.scratch[id], s.shardAgain(id)
+	if s.own[id] > 0 {
+		clear(seen) // only dirty if the previous run touched this shard
 	}
-	aggWG.Wait()
+	var own, added uint64
+	for batch := range in {
+		if cancelled(done) {
+			putBatch(batch)
+			continue
+		}
+		for _, ip := range batch {
+			off := sm.bit(ip)
+			w := off >> 6
+			m := uint64(1) << (off & 63)
+			if again != nil {
+				again[w] |= bs[w] & m
+			}
+			if seen[w]&m != 0 {
+				continue
+			}
+			seen[w] |= m
+			own++
+			if bs[w]&m == 0 {
+				bs[w] |= m
+				added++
+			}
+		}
+		if fq != nil {
+			for _, ip := range batch {
+				fq.add(sm.bit(ip))
+			}
+		}
+		putBatch(batch)
+	}
+	s.own[id], s.added[id] = own, added
+}
+
+// lastRun returns the unique and first-time unique counts of the last run (file tracking only).
+func (s *shardSet) lastRun() (own, added uint64) {
+	for id := range s.own {
+		own += s.own[id]
+		added += s.added[id]
+	}
+	return own, added
+}
+
+// count returns the number of set bits across all shards.
+func (s *shardSet) count() uint64 {
+	counts := make([]uint64, len(s.bits))
+	var wg sync.WaitGroup
+	wg.Add(len(s.bits))
+	for id := range s.bits {
+		go func(id int) {
+			defer wg.Done()
+			var c uint64
+			for _, w := range s.bits[id] {
+				c += uint64(bits.OnesCount64(w))
+			}
+			counts[id] = c
+		}(id)
+	}
+	wg.Wait()
 
 	var total uint64
 	for _, c := range counts {
 		total += c
 	}
-	return total, nil
+	return total
 }
 
 type segment struct{ lo, hi int64 }
@@ -137,7 +442,7 @@ func split(size int64, parts int) []segment {
 // alignSegments does left-only alignment on i>0 within PROBE window,
 // then stitches segments so that seg[i].hi == seg[i+1].lo and the last seg.hi == file end.
 // This guarantees no gaps/overlaps and no split lines across segments.
-func alignSegments(f *os.File, segs []segment, probe int64) error {
+func alignSegments(f io.ReaderAt, name string, segs []segment, probe int64, retry RetryPolicy) error {
 	if probe < 1 || len(segs) == 0 {
 		return nil
 	}
@@ -146,8 +451,10 @@ func alignSegments(f *os.File, segs []segment, probe int64) error {
 
 	tmp := make([]byte, probe)
 
-	// Left-align: for i>0 move lo to just after the next '\n' within window; if none, empty the segment.
-	for i := 1; i < len(segs); i++ {
+	// Left-align: for i>0 move lo to just after the next '\n' within window; if none, empty the segment
+	// onto the aligned start of the next one (right to left, so that one is already aligned):
+	// a line longer than the window then goes whole to the segment it starts in.
+	for i := len(segs) - 1; i >= 1; i-- {
 		lo, hi := orig[i].lo, orig[i].hi
 		if lo >= hi {
 			segs[i].lo = lo
@@ -157,11 +464,16 @@ func alignSegments(f *os.File, segs []segment, probe int64) error {
 		if win > probe {
 			win = probe
 		}
-		n, _ := f.ReadAt(tmp[:win], lo)
+		n, err := retry.readAt(f, tmp[:win], lo)
+		if err != nil && err != io.EOF {
+			return &ReadError{Path: name, Offset: lo + int64(n), Err: err}
+		}
 		if k := bytes.IndexByte(tmp[:n], '\n'); k >= 0 {
 			segs[i].lo = lo + int64(k+1)
-		} else {
+		} else if i+1 < len(segs) {
 			// No newline in window -> make this segment empty to avoid cutting a line.
+			segs[i].lo = segs[i+1].lo
+		} else {
 			segs[i].lo = hi
 		}
 	}
@@ -183,139 +495,257 @@ func alignSegments(f *os.File, segs []segment, probe int64) error {
 	return nil
 }
 
-func readSegmentReadAt(f *os.File, lo, hi int64, isLast bool, outs []chan []uint32, bufSize int) {
+func readSegmentReadAt(ctx context.Context, f io.ReaderAt, name string, lo, hi int64, isLast bool, k sink, bufSize int, retry RetryPolicy) error {
 	if hi <= lo {
-		return
+		return nil
 	}
 	buf := make([]byte, bufSize)
-	var local = make([][]uint32, len(outs))
-
-	// carry for boundary line (IPv4 fits ≤ 16 bytes incl. CR)
-	var carry [32]byte
-	carryLen := 0
-
-	flushLocal := func() {
-		for id, b := range local {
-			if len(b) > 0 {
-				outs[id] <- b
-				local[id] = nil
-			}
-		}
-	}
+	rt := k.router(lo)
+	defer rt.flush()
+	var carry lineCarry
 
 	pos := lo
 	for pos < hi {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		want := buf
 		if rem := hi - pos; int64(len(want)) > rem {
 			want = want[:rem]
 		}
-		n, er := f.ReadAt(want, pos)
-		if n == 0 && (er == io.EOF || er == nil) {
-			break
+		n, er := retry.readAt(f, want, pos)
+		carry.feed(rt, want[:n])
+		k.m.read(n)
+		pos += int64(n)
+
+		switch {
+		case er == io.EOF && pos < hi:
+			// the file got shorter than it was when the segments were cut
+			return &ReadError{Path: name, Offset: pos, Err: io.ErrUnexpectedEOF}
+		case er != nil && er != io.EOF:
+			return &ReadError{Path: name, Offset: pos, Err: er}
+		case n == 0 && er == nil:
+			return &ReadError{Path: name, Offset: pos, Err: io.ErrNoProgress}
 		}
-		chunk := want[:n]
-		pos += int64(len(chunk))
+	}
 
-		i := 0
+	// last segment may end without '\n'
+	if isLast {
+		carry.finish(rt)
+	}
+	return nil
+}
 
-		// complete carried line if present
-		if carryLen > 0 {
-			if k := bytes.IndexByte(chunk, '\n'); k >= 0 {
-				need := carryLen + k
-				if need > len(carry) {
-					need = len(carry) // safety (shouldn't hit with IPv4 lines)
-				}
-				copy(carry[carryLen:], chunk[:need-carryLen])
-				line := carry[:need]
-				// strip CR
-				if need > 0 && line[need-1] == '\r' {
-					line = line[:need-1]
-				}
-				if ip, ok := parseIPv4(line); ok {
-					sid := int(ip % uint32(len(outs)))
-					if local[sid] == nil {
-						local[sid] = getBatch()
-					}
-					local[sid] = append(local[sid], ip)
-					if len(local[sid]) >= batchSize {
-						outs[sid] <- local[sid]
-						local[sid] = nil
-					}
-				}
-				carryLen = 0
-				i = k + 1
-			} else {
-				// no newline in this chunk; extend carry safely
-				avail := len(carry) - carryLen
-				if avail > 0 {
-					to := len(chunk)
-					if to > avail {
-						to = avail
-					}
-					copy(carry[carryLen:], chunk[:to])
-					carryLen += to
-				}
-				continue
-			}
-		}
+// lineCarry holds the line cut at a buffer boundary (IPv4 fits ≤ 16 bytes incl. CR).
+// Of a longer line only the head is kept, for the report; its full length is still tracked
+// so line offsets stay exact.
+type lineCarry struct {
+	b    [32]byte
+	n    int
+	full int // bytes of the line so far, including what didn't fit in b
+}
 
-		// fast path: scan lines within chunk
-		for {
-			j := bytes.IndexByte(chunk[i:], '\n')
-			if j < 0 {
-				// save tail into carry (≤16 bytes)
-				if i < len(chunk) {
-					tail := chunk[i:]
-					if len(tail) > 0 {
-						if len(tail) > len(carry) {
-							tail = tail[len(tail)-len(carry):] // safety
-						}
-						copy(carry[:], tail)
-						carryLen = len(tail)
-					}
-				}
-				break
-			}
-			end := i + j
-			line := chunk[i:end]
-			// strip CR
-			if ln := len(line); ln > 0 && line[ln-1] == '\r' {
-				line = line[:ln-1]
-			}
-			if ip, ok := parseIPv4(line); ok {
-				sid := int(uint32(ip) % uint32(len(outs)))
-				if local[sid] == nil {
-					local[sid] = getBatch()
-				}
-				local[sid] = append(local[sid], ip)
-				if len(local[sid]) >= batchSize {
-					outs[sid] <- local[sid]
-					local[sid] = nil
-				}
-			}
-			i = end + 1
+// feed parses the lines of chunk, completing the carried line first, and carries the unterminated tail.
+func (c *lineCarry) feed(rt *router, chunk []byte) {
+	// complete carried line if present
+	if c.full > 0 {
+		k := bytes.IndexByte(chunk, '\n')
+		if k < 0 {
+			// no newline in this chunk; extend carry safely
+			c.hold(chunk)
+			return
 		}
+		c.hold(chunk[:k])
+		c.emit(rt)
+		rt.off++ // the '\n'
+		chunk = chunk[k+1:]
+	}
+
+	// fast path: scan lines within chunk, save tail into carry (≤16 bytes)
+	if tail := rt.lines(chunk); len(tail) > 0 {
+		c.hold(tail)
+	}
+}
+
+// hold appends p to the carried line, keeping only what fits.
+func (c *lineCarry) hold(p []byte) {
+	c.n += copy(c.b[c.n:], p)
+	c.full += len(p)
+}
+
+// emit parses the carried line, or reports it as too long if it didn't fit, and moves rt past it.
+func (c *lineCarry) emit(rt *router) {
+	if c.full > len(c.b) {
+		rt.longLine(c.b[:c.n])
+	} else {
+		rt.line(c.b[:c.n])
+	}
+	rt.off += int64(c.full)
+	c.n, c.full = 0, 0
+}
+
+// finish parses the carried line as the last one of the input.
+func (c *lineCarry) finish(rt *router) {
+	if c.full > 0 {
+		c.emit(rt)
+	}
+}
+
+// sink is where a reader's lines go: the shard aggregator inputs, its progress meter and the line tally.
+type sink struct {
+	outs  []chan []uint32
+	sm    shardMap
+	m     meter
+	lines *lineTally
+}
 
-		if er == io.EOF {
-			break
+// sink returns the sink of one reader of the current run.
+func (s *shardSet) sink(outs []chan []uint32, m meter) sink {
+	return sink{outs: outs, sm: s.sm, m: m, lines: s.lines}
+}
+
+// router returns a router for lines starting at input offset off.
+func (k sink) router(off int64) *router {
+	return &router{outs: k.outs, local: make([][]uint32, len(k.outs)), sm: k.sm, m: k.m, t: k.lines, off: off}
+}
+
+// router parses lines and buffers the resulting IPs per shard,
+// handing full batches to the shard aggregators. It tallies lines by kind
+// and keeps the earliest invalid ones until flushed into the run's tally.
+type router struct {
+	outs     []chan []uint32
+	local    [][]uint32
+	sm       shardMap
+	m        meter
+	reported uint64                     // lines already reported to m
+	visit    func(ip uint32, off int64) // if set, gets every IPv4 with its line offset instead of the shards
+
+	t      *lineTally
+	off    int64 // input offset of the next line
+	stats  LineStats
+	bad    []InvalidLine // at most t.samples, in the order seen
+	strict *LineError    // first malformed line in strict mode
+}
+
+// line parses a single line without its '\n' (a trailing '\r' is stripped), starting at r.off.
+// The caller moves r.off past the line.
+func (r *router) line(line []byte) {
+	if r.strict != nil {
+		return // the run is being stopped
+	}
+	r.stats.Lines++
+	if ln := len(line); ln > 0 && line[ln-1] == '\r' {
+		line = line[:ln-1]
+	}
+	if ip, ok := parseIPv4(line); ok {
+		r.stats.Valid++
+		if r.visit != nil {
+			r.visit(ip, r.off)
+			return
 		}
+		r.push(ip)
+		return
+	}
+	if len(line) == 0 {
+		r.stats.Blank++
+		return
+	}
+	if len(line) > maxLineLen {
+		r.stats.Long++
 	}
+	r.invalid(line)
+}
 
-	// last segment may end without '\n'
-	if isLast && carryLen > 0 {
-		line := carry[:carryLen]
-		if ln := len(line); ln > 0 && line[ln-1] == '\r' {
-			line = line[:ln-1]
+// longLine accounts a line too long to be held whole, given its first bytes. Such a line is never
+// parsed: a fragment of it could pass for a different IPv4. It starts at r.off; the caller moves r.off past it.
+func (r *router) longLine(head []byte) {
+	if r.strict != nil {
+		return
+	}
+	r.stats.Lines++
+	r.stats.Long++
+	r.invalid(head)
+}
+
+// invalid accounts a malformed line already counted in Lines.
+func (r *router) invalid(line []byte) {
+	r.stats.Invalid++
+	if r.t.strict {
+		r.strict = &LineError{InvalidLine: r.sample(line)}
+		r.t.stop()
+		return
+	}
+	if len(r.bad) < r.t.samples {
+		r.bad = append(r.bad, r.sample(line))
+	}
+}
+
+func (r *router) sample(line []byte) InvalidLine {
+	return InvalidLine{Offset: r.off, Line: string(line[:min(len(line), maxSampleLine)])}
+}
+
+// lines parses every '\n'-terminated line in chunk and returns the unterminated tail,
+// leaving r.off at its start.
+func (r *router) lines(chunk []byte) []byte {
+	i := 0
+	for {
+		j := bytes.IndexByte(chunk[i:], '\n')
+		if j < 0 {
+			return chunk[i:]
 		}
-		if ip, ok := parseIPv4(line); ok {
-			sid := int(uint32(ip) % uint32(len(outs)))
-			b := getBatch()
-			b = append(b, ip)
-			outs[sid] <- b
+		end := i + j
+		r.line(chunk[i:end])
+		r.off += int64(j + 1)
+		i = end + 1
+	}
+}
+
+func (r *router) push(ip uint32) {
+	sid := r.sm.shard(ip)
+	if r.local[sid] == nil {
+		r.local[sid] = getBatch()
+	}
+	r.local[sid] = append(r.local[sid], ip)
+	if len(r.local[sid]) >= batchSize {
+		r.outs[sid] <- r.local[sid]
+		r.local[sid] = nil
+		r.report()
+	}
+}
+
+// report hands the lines parsed so far to the meter.
+func (r *router) report() {
+	r.m.parsed(int64(r.stats.Lines - r.reported))
+	r.reported = r.stats.Lines
+}
+
+// flush hands the buffered IPs to the aggregators and the tally to the run.
+func (r *router) flush() {
+	r.send()
+	r.t.merge(r)
+	r.reported = 0
+}
+
+// send hands the buffered IPs to the aggregators, keeping the tally for a later merge.
+func (r *router) send() {
+	r.report()
+	for id, b := range r.local {
+		if len(b) > 0 {
+			r.outs[id] <- b
+			r.local[id] = nil
 		}
 	}
+}
 
-	flushLocal()
+// rebase shifts the offsets of a router that counted from the start of a range, once its place
+// in the input is known.
+func (r *router) rebase(base int64) {
+	for i := range r.bad {
+		r.bad[i].Offset += base
+	}
+	if r.strict != nil {
+		r.strict.Offset += base
+	}
 }
 
 const batchSize = 32768
@@ -347,7 +777,7 @@ func parseIPv4(b []byte) (uint32, bool) {
 	i = n + 1
 	a3, n = dec3(b, i)
 	// Allow optional trailing '\r' without '\n'.
-	if a3 > 255 {
+	if n == i || a3 > 255 {
 		return 0, false
 	}
 	switch {
@@ -0,0 +1,149 @@
+package read
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+
+	"github.com/Borislavv/ip-file-counter/internal/ipset"
+	"github.com/Borislavv/ip-file-counter/internal/snapshot"
+)
+
+// Snapshots carries a run's set over from one run to the next as .ipset snapshots.
+type Snapshots struct {
+	Load        io.Reader            // if set, the run starts from the set of this snapshot or portable Roaring bitmap, read once the first input is open
+	Save        io.Writer            // if set, the final set is written here once the run succeeds
+	Compression snapshot.Compression // of Save
+
+	Loaded uint64 // addresses of the loaded snapshot, set once it's read; the rest of the count is newly seen
+}
+
+// load feeds the set of sn.Load into the freshly allocated bitsets: a snapshot has its windows read
+// in turn and their addresses routed to the aggregators by R parallel workers; a portable Roaring
+// bitmap, told apart by its cookie, is read whole first.
+func (s *shardSet) load(sn *Snapshots) error {
+	br := bufio.NewReader(sn.Load)
+	if c, _ := br.Peek(2); len(c) == 2 && c[1] == 0x30 && (c[0] == 0x3A || c[0] == 0x3B) {
+		return s.loadRoaring(br, sn)
+	}
+	sr, err := snapshot.NewReader(br)
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+	s.loading = true
+	defer func() { s.loading = false }()
+	err = s.run(func(outs []chan []uint32) error {
+		R := s.cfg.Readers
+		free := make(chan []uint64, R+1)
+		for range R + 1 {
+			free <- make([]uint64, snapshot.WindowWords)
+		}
+		type window struct {
+			lo uint32
+			w  []uint64
+		}
+		work := make(chan window, R)
+		var wg sync.WaitGroup
+		wg.Add(R)
+		for range R {
+			go func() {
+				defer wg.Done()
+				rt := s.sink(outs, meter{}).router(0)
+				for win := range work {
+					for k, word := range win.w {
+						for ; word != 0; word &= word - 1 {
+							rt.push(win.lo + uint32(k<<6|bits.TrailingZeros64(word)))
+						}
+					}
+					free <- win.w
+				}
+				rt.send()
+			}()
+		}
+		var err error
+		for s.ctx.Err() == nil {
+			w := <-free
+			var lo uint32
+			var n int
+			if lo, n, err = sr.Next(w); err != nil {
+				break
+			}
+			if n == 0 {
+				free <- w
+				continue
+			}
+			work <- window{lo, w}
+		}
+		close(work)
+		wg.Wait()
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		if cerr := s.ctx.Err(); cerr != nil {
+			return cerr
+		}
+		return fmt.Errorf("load: %w", err)
+	}
+	sn.Loaded = sr.Len()
+	return nil
+}
+
+// loadRoaring feeds the portable Roaring bitmap of r into the bitsets.
+func (s *shardSet) loadRoaring(r io.Reader, sn *Snapshots) error {
+	set, err := ipset.ReadRoaring(r)
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+	s.loading = true
+	defer func() { s.loading = false }()
+	err = s.run(func(outs []chan []uint32) error {
+		rt := s.sink(outs, meter{}).router(0)
+		for ip := range set.All() {
+			if ip&0xFFFF == 0 && s.ctx.Err() != nil {
+				break
+			}
+			rt.push(ip)
+		}
+		rt.send()
+		return nil
+	})
+	if err == nil {
+		err = s.ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	sn.Loaded = set.Len()
+	return nil
+}
+
+// save writes the set to w as a snapshot, in address order.
+func (s *shardSet) save(w io.Writer, c snapshot.Compression) error {
+	sw, err := snapshot.NewWriter(w, c)
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	if s.bits != nil {
+		err = s.ascend(PickAll, nil, func(_ int, lo uint32, win []uint64) error {
+			if err := s.ctx.Err(); err != nil {
+				return err
+			}
+			if err := sw.Window(lo, win); err != nil {
+				return fmt.Errorf("save: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := sw.Close(); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package read
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// UniqueIPv4CountReader counts unique IPv4s in a sequential stream (stdin, a named pipe, a decompressor).
+// gzip, bzip2 and zlib streams are recognized by their magic bytes and decoded on the fly.
+// The stream is cut into newline-aligned blocks which are parsed by parallel workers
+// feeding the same shard aggregators as the ReadAt path.
+func UniqueIPv4CountReader(r io.Reader, shards, readers, bufMb int) (uint64, error) {
+	return UniqueIPv4CountReaderContext(context.Background(), r, Config{Shards: shards, Readers: readers, BufMB: bufMb})
+}
+
+// UniqueIPv4CountReaderContext is UniqueIPv4CountReader that stops once ctx is done and returns ctx.Err().
+// Where r is an *os.File that supports deadlines, a read blocked on it is cut short too.
+func UniqueIPv4CountReaderContext(ctx context.Context, r io.Reader, cfg Config) (uint64, error) {
+	cfg.Progress.begin(0)
+	set := newShardSet(ctx, cfg)
+	defer set.close()
+	set.lines.begin(Stdin)
+	if err := set.addReader(r, ""); err != nil {
+		return 0, err
+	}
+	return set.finish()
+}
+
+// addReader feeds a sequential stream into the set, decompressing it if needed.
+// The name, if any, is only used to recognize compressed input by its extension.
+func (s *shardSet) addReader(r io.Reader, name string) error {
+	return s.stream(r, name, func(src io.Reader, m meter) error {
+		return s.run(func(outs []chan []uint32) error {
+			return streamBlocks(s.ctx, src, s.cfg.Readers, s.cfg.bufSize(), s.sink(outs, m), parseBlock(s.ctx))
+		})
+	})
+}
+
+// stream hands fn the decoded contents of r and the meter of its raw bytes. A blocked read on r
+// is cut short once the set stops, where r allows it.
+func (s *shardSet) stream(r io.Reader, name string, fn func(src io.Reader, m meter) error) error {
+	if f, ok := r.(*os.File); ok {
+		// A pipe read can block for good: cut it short on cancellation where the file supports deadlines.
+		stop := context.AfterFunc(s.ctx, func() { _ = f.SetReadDeadline(time.Now()) })
+		defer stop()
+	}
+	m := s.cfg.Progress.meters(1)[0]
+	src, err := decompressStream(meteredReader{r: r, m: m}, name)
+	if err != nil {
+		if cerr := s.err(); cerr != nil {
+			return cerr
+		}
+		if name != "" {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return err
+	}
+	return fn(src, m)
+}
+
+// blockFunc handles block seq (numbered from 0 in stream order) at stream offset off with a worker's router.
+// It's called for every block, even once ctx is done, and b is only valid during the call.
+type blockFunc func(rt *router, b []byte, off, seq int64)
+
+// parseBlock is the blockFunc of a count: it parses the lines of b unless ctx is done.
+func parseBlock(ctx context.Context) blockFunc {
+	return func(rt *router, b []byte, off, _ int64) {
+		// only the last block may end without '\n'
+		if ctx.Err() == nil {
+			rt.off = off
+			if tail := rt.lines(b); len(tail) > 0 {
+				rt.line(tail)
+			}
+		}
+	}
+}
+
+// streamBlocks reads r into blocks of up to bufSize bytes, each ending on a '\n' (except the last one),
+// and hands them to R workers running fn. The partial line after the last '\n' is moved to the next block.
+// At most R+1 blocks are allocated; workers return them for reuse. Reading stops once ctx is done.
+// The caller meters the bytes read from the underlying input; line offsets are those of the stream.
+func streamBlocks(ctx context.Context, r io.Reader, R, bufSize int, k sink, fn blockFunc) error {
+	if bufSize < 1 {
+		bufSize = 1
+	}
+	type block struct {
+		b        []byte
+		off, seq int64 // stream offset of b[0], block number
+	}
+	free := make(chan []byte, R+1)
+	work := make(chan block, R)
+
+	var wg sync.WaitGroup
+	wg.Add(R)
+	for i := 0; i < R; i++ {
+		go func() {
+			defer wg.Done()
+			rt := k.router(0)
+			for blk := range work {
+				fn(rt, blk.b, blk.off, blk.seq)
+				free <- blk.b[:cap(blk.b)]
+			}
+			rt.flush()
+		}()
+	}
+
+	allocated := 0
+	getBuf := func() []byte {
+		select {
+		case b := <-free:
+			return b
+		default:
+		}
+		if allocated < R+1 {
+			allocated++
+			return make([]byte, bufSize)
+		}
+		return <-free
+	}
+
+	var (
+		err      error
+		carryLen int
+		off      int64 // stream offset of buf[0]
+		skipping bool  // dropping the rest of a line longer than a whole block
+		seq      int64
+	)
+	long := k.router(0) // accounts the lines skipped here
+	defer long.flush()
+	buf := getBuf()
+	for {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		n, er := fill(r, buf[carryLen:])
+		data := buf[:carryLen+n]
+		carryLen = 0
+
+		if skipping {
+			if k := bytes.IndexByte(data, '\n'); k >= 0 {
+				data = buf[:copy(buf, data[k+1:])]
+				off += int64(k + 1)
+				skipping = false
+			} else {
+				off += int64(len(data))
+				data = data[:0]
+			}
+			if er == nil {
+				// refill first: what's left may be the start of a line cut by the block end
+				carryLen = len(data)
+				continue
+			}
+		}
+
+		if er != nil {
+			if er != io.EOF {
+				err = er
+				break
+			}
+			if len(data) > 0 {
+				work <- block{data, off, seq}
+			}
+			break
+		}
+
+		k := bytes.LastIndexByte(data, '\n')
+		if k < 0 && len(data) < len(buf) {
+			carryLen = len(data)
+			continue
+		}
+		if k < 0 {
+			// A single line doesn't fit into the block: it can't be an IPv4, report and drop it.
+			long.off = off
+			long.longLine(data[:min(len(data), maxSampleLine)])
+			off += int64(len(data))
+			skipping = true
+			continue
+		}
+		// move the partial line into the next block before handing this one off
+		next := getBuf()
+		carryLen = copy(next, data[k+1:])
+		work <- block{data[:k+1], off, seq}
+		seq++
+		off += int64(k + 1)
+		buf = next
+	}
+
+	close(work)
+	wg.Wait()
+	return err
+}
+
+// fill reads into buf until it's full or the stream ends. Unlike io.ReadFull it passes the reader's
+// errors through as is, so a decoder's io.ErrUnexpectedEOF is not mistaken for a clean short read.
+func fill(r io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,416 @@
+//go:build linux
+
+package read
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// uringDepth is how many block reads each reader keeps in flight.
+const uringDepth = 4
+
+// uringMinBlock keeps blocks large enough that a deep queue doesn't turn into tiny reads.
+const uringMinBlock = 64 << 10
+
+// addSegmentsURing reads every segment through its own io_uring, keeping uringDepth block reads
+// in flight and parsing completed blocks in file order. Without a usable io_uring it falls back to pread.
+func (s *shardSet) addSegmentsURing(f *os.File, size int64, segs []segment) error {
+	rings := make([]*uring, len(segs))
+	for i := range rings {
+		r, err := newURing(uringDepth)
+		if err != nil {
+			for _, r := range rings[:i] {
+				r.close()
+			}
+			return s.addSegmentsPread(f, f.Name(), size, segs)
+		}
+		rings[i] = r
+	}
+	defer func() {
+		for _, r := range rings {
+			r.close()
+		}
+	}()
+
+	fd := int(f.Fd())
+	block := max(s.cfg.bufSize()/uringDepth, uringMinBlock)
+	meters := s.cfg.Progress.meters(len(segs))
+	return s.run(func(outs []chan []uint32) error {
+		errs := make([]error, len(segs))
+		var rdWG sync.WaitGroup
+		rdWG.Add(len(segs))
+		for i := range segs {
+			seg := segs[i]
+			go func(i int, lo, hi int64, last bool) {
+				defer rdWG.Done()
+				// completions may run as task work of the thread that submitted the reads: stay on it
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+				errs[i] = readSegmentURing(s.ctx, rings[i], f, fd, lo, hi, last, s.sink(outs, meters[i]), block, s.cfg.Retry)
+			}(i, seg.lo, seg.hi, seg.hi == size)
+		}
+		rdWG.Wait()
+		return errors.Join(errs...)
+	})
+}
+
+// uringSlot is one block buffer and the read it's currently used for.
+type uringSlot struct {
+	buf  []byte
+	off  int64
+	want int
+	res  int32
+	done bool
+}
+
+func readSegmentURing(ctx context.Context, ring *uring, f *os.File, fd int, lo, hi int64, isLast bool, k sink, block int, retry RetryPolicy) error {
+	if hi <= lo {
+		return nil
+	}
+	rt := k.router(lo)
+	defer rt.flush()
+	var carry lineCarry
+
+	slots := make([]uringSlot, ring.entries)
+	for i := range slots {
+		slots[i].buf = make([]byte, block)
+	}
+	var (
+		next      = lo // next offset to queue
+		queued    uint64
+		processed uint64
+		inflight  int
+	)
+	queue := func() {
+		for next < hi && queued-processed < uint64(len(slots)) {
+			sl := &slots[queued%uint64(len(slots))]
+			sl.off, sl.want, sl.done = next, int(min(int64(block), hi-next)), false
+			ring.queueRead(fd, sl.buf[:sl.want], sl.off, queued)
+			next += int64(sl.want)
+			queued++
+			inflight++
+		}
+	}
+	complete := func(userData uint64, res int32) {
+		sl := &slots[userData%uint64(len(slots))]
+		sl.res, sl.done = res, true
+		inflight--
+	}
+	// wait blocks until the slot of sequence seq has completed.
+	wait := func(seq uint64) error {
+		for {
+			ring.reap(complete)
+			if slots[seq%uint64(len(slots))].done {
+				return nil
+			}
+			if err := ring.submit(1); uringBusy(err) {
+				time.Sleep(time.Millisecond)
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	// drain waits for every queued read so no buffer is written after we return. If the ring can't
+	// be waited on any more, it's closed, which cancels the reads, and the buffers are kept for good:
+	// the kernel may still write into them.
+	defer func() {
+		for {
+			ring.reap(complete)
+			if inflight == 0 {
+				return
+			}
+			err := ring.submit(1)
+			if uringBusy(err) {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			if err != nil {
+				ring.close()
+				uringOrphans.Lock()
+				uringOrphans.slots = append(uringOrphans.slots, slots)
+				uringOrphans.Unlock()
+				return
+			}
+		}
+	}()
+
+	queue()
+	if err := ring.submit(0); err != nil {
+		return &ReadError{Path: f.Name(), Offset: lo, Err: err}
+	}
+	for processed < queued {
+		if err := ctx.Err(); err != nil {
+			return err // the deferred drain still waits for reads in flight
+		}
+		if err := wait(processed); err != nil {
+			return &ReadError{Path: f.Name(), Offset: slots[processed%uint64(len(slots))].off, Err: err}
+		}
+		sl := &slots[processed%uint64(len(slots))]
+		n := 0
+		if sl.res >= 0 {
+			n = int(sl.res)
+		} else if errno := unix.Errno(-sl.res); !isTransient(errno) {
+			return &ReadError{Path: f.Name(), Offset: sl.off, Err: errno}
+		}
+		if n < sl.want {
+			// short or interrupted read: finish the block synchronously
+			m, err := retry.readAt(f, sl.buf[n:sl.want], sl.off+int64(n))
+			n += m
+			if err == io.EOF && sl.off+int64(n) < hi {
+				err = io.ErrUnexpectedEOF // the file got shorter than it was when the segments were cut
+			}
+			if err != nil && err != io.EOF {
+				return &ReadError{Path: f.Name(), Offset: sl.off + int64(n), Err: err}
+			}
+		}
+		carry.feed(rt, sl.buf[:n])
+		k.m.read(n)
+		processed++
+
+		queue()
+		if err := ring.submit(0); err != nil {
+			return &ReadError{Path: f.Name(), Offset: next, Err: err}
+		}
+	}
+
+	// last segment may end without '\n'
+	if isLast {
+		carry.finish(rt)
+	}
+	return nil
+}
+
+// uringOrphans keeps the buffers of reads that were still in flight when their ring failed.
+var uringOrphans struct {
+	sync.Mutex
+	slots [][]uringSlot
+}
+
+// uringBusy reports whether io_uring_enter failed for lack of resources or room for completions,
+// which clears up once the kernel catches up.
+func uringBusy(err error) bool {
+	return errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EBUSY)
+}
+
+// Kernel ABI (include/uapi/linux/io_uring.h).
+const (
+	iouringOffSQRing = 0
+	iouringOffCQRing = 0x8000000
+	iouringOffSQEs   = 0x10000000
+
+	iouringFeatSingleMmap = 1 << 0
+	iouringEnterGetEvents = 1 << 0
+	iouringRegisterProbe  = 8
+	iouringOpSupported    = 1 << 0
+	iouringOpRead         = 22
+)
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type uringProbeOp struct {
+	op    uint8
+	resv  uint8
+	flags uint16
+	resv2 uint32
+}
+
+type uringProbe struct {
+	lastOp uint8
+	opsLen uint8
+	resv   uint16
+	resv2  [3]uint32
+	ops    [256]uringProbeOp
+}
+
+// uring is a minimal single-goroutine io_uring driving IORING_OP_READ.
+type uring struct {
+	fd      int
+	entries uint32
+	sqRing  []byte
+	cqRing  []byte // aliases sqRing with IORING_FEAT_SINGLE_MMAP
+	sqeMem  []byte
+
+	sqTail, sqArray *uint32
+	sqMask          uint32
+	cqHead, cqTail  *uint32
+	cqMask          uint32
+	cqes            unsafe.Pointer
+	sqes            unsafe.Pointer
+	pending         uint32 // queued SQEs not yet handed to the kernel
+}
+
+func newURing(entries uint32) (*uring, error) {
+	var p uringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	r := &uring{fd: int(fd), entries: p.sqEntries}
+	if err := r.probeRead(); err != nil {
+		_ = unix.Close(r.fd)
+		return nil, err
+	}
+
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	single := p.features&iouringFeatSingleMmap != 0
+	if single {
+		sqSize = max(sqSize, cqSize)
+	}
+	var err error
+	if r.sqRing, err = unix.Mmap(r.fd, iouringOffSQRing, sqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		r.close()
+		return nil, fmt.Errorf("io_uring sq ring mmap: %w", err)
+	}
+	r.cqRing = r.sqRing
+	if !single {
+		if r.cqRing, err = unix.Mmap(r.fd, iouringOffCQRing, cqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+			r.close()
+			return nil, fmt.Errorf("io_uring cq ring mmap: %w", err)
+		}
+	}
+	sqeSize := int(p.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	if r.sqeMem, err = unix.Mmap(r.fd, iouringOffSQEs, sqeSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		r.close()
+		return nil, fmt.Errorf("io_uring sqes mmap: %w", err)
+	}
+
+	u32 := func(ring []byte, off uint32) *uint32 { return (*uint32)(unsafe.Pointer(&ring[off])) }
+	r.sqTail, r.sqArray = u32(r.sqRing, p.sqOff.tail), u32(r.sqRing, p.sqOff.array)
+	r.sqMask = *u32(r.sqRing, p.sqOff.ringMask)
+	r.cqHead, r.cqTail = u32(r.cqRing, p.cqOff.head), u32(r.cqRing, p.cqOff.tail)
+	r.cqMask = *u32(r.cqRing, p.cqOff.ringMask)
+	r.cqes = unsafe.Pointer(&r.cqRing[p.cqOff.cqes])
+	r.sqes = unsafe.Pointer(&r.sqeMem[0])
+	return r, nil
+}
+
+// probeRead makes sure the kernel knows IORING_OP_READ (5.6+).
+func (r *uring) probeRead() error {
+	var p uringProbe
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(r.fd), iouringRegisterProbe,
+		uintptr(unsafe.Pointer(&p)), uintptr(len(p.ops)), 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("io_uring probe: %w", errno)
+	}
+	if p.lastOp < iouringOpRead || p.ops[iouringOpRead].flags&iouringOpSupported == 0 {
+		return errors.New("io_uring: IORING_OP_READ not supported")
+	}
+	return nil
+}
+
+// close unmaps the rings and closes the ring fd, cancelling reads in flight. It may be called again.
+func (r *uring) close() {
+	if r.fd < 0 {
+		return
+	}
+	if r.sqeMem != nil {
+		_ = unix.Munmap(r.sqeMem)
+	}
+	if r.cqRing != nil && len(r.sqRing) > 0 && &r.cqRing[0] != &r.sqRing[0] {
+		_ = unix.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		_ = unix.Munmap(r.sqRing)
+	}
+	_ = unix.Close(r.fd)
+	r.fd = -1
+}
+
+// queueRead fills the next SQE with a read of len(buf) bytes at off. The caller never queues
+// more than entries reads at once, so the SQ ring can't overflow.
+func (r *uring) queueRead(fd int, buf []byte, off int64, userData uint64) {
+	tail := atomic.LoadUint32(r.sqTail)
+	idx := tail & r.sqMask
+	sqe := (*uringSQE)(unsafe.Add(r.sqes, uintptr(idx)*unsafe.Sizeof(uringSQE{})))
+	*sqe = uringSQE{
+		opcode:   iouringOpRead,
+		fd:       int32(fd),
+		off:      uint64(off),
+		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:      uint32(len(buf)),
+		userData: userData,
+	}
+	*(*uint32)(unsafe.Add(unsafe.Pointer(r.sqArray), uintptr(idx)*4)) = idx
+	atomic.StoreUint32(r.sqTail, tail+1)
+	r.pending++
+}
+
+// submit hands queued SQEs to the kernel and, with wait > 0, blocks until that many completions are ready.
+func (r *uring) submit(wait uint32) error {
+	var flags uintptr
+	if wait > 0 {
+		flags = iouringEnterGetEvents
+	}
+	for {
+		n, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(r.pending), uintptr(wait), flags, 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+		r.pending -= uint32(n)
+		return nil
+	}
+}
+
+// reap hands every available completion to fn.
+func (r *uring) reap(fn func(userData uint64, res int32)) {
+	head := atomic.LoadUint32(r.cqHead)
+	tail := atomic.LoadUint32(r.cqTail)
+	for ; head != tail; head++ {
+		cqe := (*uringCQE)(unsafe.Add(r.cqes, uintptr(head&r.cqMask)*unsafe.Sizeof(uringCQE{})))
+		fn(cqe.userData, cqe.res)
+	}
+	atomic.StoreUint32(r.cqHead, head)
+}
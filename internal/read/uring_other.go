@@ -0,0 +1,10 @@
+//go:build !linux
+
+package read
+
+import "os"
+
+// addSegmentsURing falls back to pread where io_uring doesn't exist.
+func (s *shardSet) addSegmentsURing(f *os.File, size int64, segs []segment) error {
+	return s.addSegmentsPread(f, f.Name(), size, segs)
+}
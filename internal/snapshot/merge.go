@@ -0,0 +1,192 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// MaxOpen is how many inputs MergeFiles reads at once: each holds a file and its read buffers open.
+const MaxOpen = 64
+
+// MergeFiles reads the snapshots at paths in lockstep, window by window, and returns the cardinality
+// of their union; if w is not nil, the union is written there as a snapshot too. Inputs are split into
+// GOMAXPROCS groups, each read and ORed by its own goroutine, so memory stays at a few windows per group
+// plus the read buffers of at most MaxOpen inputs, whatever their number and size. Every input's checksum
+// is verified; errors come back prefixed with the path they concern. It stops with ctx.Err() once ctx is done.
+//
+// Beyond MaxOpen inputs, the union is folded through a temporary snapshot in os.TempDir: the first MaxOpen
+// are merged into it, then it and the next MaxOpen-1 into another, and so on, so at most two exist at once.
+func MergeFiles(ctx context.Context, paths []string, w io.Writer, c Compression) (uint64, error) {
+	var acc string // the union so far, a temporary snapshot
+	defer func() {
+		if acc != "" {
+			_ = os.Remove(acc)
+		}
+	}()
+	for len(paths) > MaxOpen {
+		next, err := mergeTemp(ctx, paths[:MaxOpen])
+		if acc != "" {
+			_ = os.Remove(acc)
+		}
+		if acc = next; err != nil {
+			return 0, err
+		}
+		paths = append([]string{acc}, paths[MaxOpen:]...)
+	}
+	return mergeOpen(ctx, paths, w, c)
+}
+
+// mergeTemp merges the snapshots at paths into a new temporary snapshot and returns its path,
+// which is empty if it couldn't be created and removed by the caller otherwise.
+func mergeTemp(ctx context.Context, paths []string) (string, error) {
+	f, err := os.CreateTemp("", "merge-*.ipset")
+	if err != nil {
+		return "", err
+	}
+	_, err = mergeOpen(ctx, paths, f, None)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return f.Name(), err
+}
+
+// mergeOpen is MergeFiles over inputs few enough to read all at once.
+func mergeOpen(ctx context.Context, paths []string, w io.Writer, c Compression) (uint64, error) {
+	srcs := make([]*Reader, len(paths))
+	for i, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		if srcs[i], err = NewReader(f); err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	var sw *Writer
+	if w != nil {
+		var err error
+		if sw, err = NewWriter(w, c); err != nil {
+			return 0, err
+		}
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	G := min(runtime.GOMAXPROCS(0), len(srcs))
+	out := make([]chan []uint64, G)  // a group's windows in order, nil when empty
+	free := make([]chan []uint64, G) // a group's spare windows
+	errs := make([]error, G)
+	var wg sync.WaitGroup
+	wg.Add(G)
+	for g := range G {
+		out[g], free[g] = make(chan []uint64, 2), make(chan []uint64, 3)
+		for range cap(free[g]) {
+			free[g] <- make([]uint64, WindowWords)
+		}
+		go func() {
+			defer wg.Done()
+			defer close(out[g])
+			if err := mergeGroup(ctx, srcs, paths, g, G, out[g], free[g]); err != nil {
+				errs[g] = err
+				cancel()
+			}
+		}()
+	}
+
+	var n uint64
+	var err error
+	acc := make([]uint64, WindowWords)
+windows:
+	for i := range Windows {
+		clear(acc)
+		for g := range G {
+			win, ok := <-out[g]
+			if !ok {
+				break windows // the group failed or ctx is done
+			}
+			if win != nil {
+				for k, word := range win {
+					acc[k] |= word
+				}
+				free[g] <- win
+			}
+		}
+		if sw != nil {
+			if err = sw.Window(uint32(i)<<WindowBits, acc); err != nil {
+				cancel()
+				break
+			}
+		} else {
+			for _, word := range acc {
+				n += uint64(bits.OnesCount64(word))
+			}
+		}
+	}
+	cancel()
+	for g := range G {
+		for range out[g] { // unblock the groups still sending
+		}
+	}
+	wg.Wait()
+	if gerr := errors.Join(errs...); gerr != nil {
+		return 0, gerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := parent.Err(); err != nil {
+		return 0, err
+	}
+	if sw != nil {
+		if err := sw.Close(); err != nil {
+			return 0, err
+		}
+		n = sw.Len()
+	}
+	return n, nil
+}
+
+// mergeGroup ORs, window by window, the inputs g, g+G, g+2G... into windows taken from free,
+// sending each to out (nil when empty), then checks their trailers.
+func mergeGroup(ctx context.Context, srcs []*Reader, paths []string, g, G int, out, free chan []uint64) error {
+	for range Windows {
+		var win []uint64
+		select {
+		case win = <-free:
+		case <-ctx.Done():
+			return nil
+		}
+		clear(win)
+		total := 0
+		for j := g; j < len(srcs); j += G {
+			_, n, err := srcs[j].Or(win)
+			if err != nil {
+				return fmt.Errorf("%s: %w", paths[j], err)
+			}
+			total += n
+		}
+		if total == 0 {
+			free <- win
+			win = nil
+		}
+		select {
+		case out <- win:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	for j := g; j < len(srcs); j += G {
+		if _, _, err := srcs[j].Next(nil); err != io.EOF {
+			return fmt.Errorf("%s: %w", paths[j], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+// Package snapshot reads and writes .ipset files: a set of IPv4 addresses saved as the 2^32-bit
+// bitset it is, window by window in address order.
+//
+// A file is a 16-byte header followed by the payload, gzip-compressed if the header says so:
+//
+//	header   "IPSET\x00", version uint16, compression uint8, window bits uint8, 6 zero bytes
+//	payload  one record per window of 2^20 addresses, in order, then the trailer
+//	record   kind uint8: 0 empty; 1 bitmap, 16384 uint64 words; 2 array, a uint32 count n
+//	         and n ascending uint32 offsets into the window
+//	trailer  cardinality uint64, CRC-32C of the payload before the checksum uint32
+//
+// Integers are little-endian.
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math/bits"
+)
+
+const (
+	// Version is the format version written.
+	Version = 1
+
+	// WindowBits is the log2 of the addresses a record covers.
+	WindowBits = 20
+	// Windows is the number of records of a file.
+	Windows = 1 << (32 - WindowBits)
+	// WindowWords is the length of a window as a bitset.
+	WindowWords = 1 << WindowBits / 64
+
+	headerSize = 16
+	// arrayMax is the most addresses a window holds as an array: beyond, the bitmap is smaller.
+	arrayMax = WindowWords*8/4 - 1
+)
+
+var magic = [6]byte{'I', 'P', 'S', 'E', 'T', 0}
+
+var (
+	// ErrFormat is returned for input that isn't a snapshot or is malformed.
+	ErrFormat = errors.New("snapshot: not an ipset snapshot")
+	// ErrChecksum is returned when the payload doesn't match its checksum or cardinality.
+	ErrChecksum = errors.New("snapshot: checksum mismatch")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Compression is how a snapshot's payload is compressed.
+type Compression uint8
+
+const (
+	None Compression = iota
+	Gzip
+)
+
+var compressionNames = [...]string{
+	None: "none",
+	Gzip: "gzip",
+}
+
+func (c Compression) String() string {
+	if int(c) < len(compressionNames) {
+		return compressionNames[c]
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+// ParseCompression maps a compression name ("none", "gzip") to its Compression.
+func ParseCompression(s string) (Compression, error) {
+	for c, name := range compressionNames {
+		if name == s {
+			return Compression(c), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown snapshot compression %q", s)
+}
+
+// Writer writes a snapshot window by window.
+type Writer struct {
+	zw   *gzip.Writer  // nil unless compressing
+	out  io.Writer     // where the payload goes: zw or the underlying writer
+	bw   *bufio.Writer // over out and crc
+	crc  hash.Hash32
+	next int // the window to write next
+	n    uint64
+	buf  []byte
+}
+
+// NewWriter writes the header of a snapshot to w and returns a Writer for its windows.
+func NewWriter(w io.Writer, c Compression) (*Writer, error) {
+	if int(c) >= len(compressionNames) {
+		return nil, fmt.Errorf("snapshot: unknown compression %d", c)
+	}
+	var h [headerSize]byte
+	copy(h[:], magic[:])
+	binary.LittleEndian.PutUint16(h[6:], Version)
+	h[8], h[9] = byte(c), WindowBits
+	if _, err := w.Write(h[:]); err != nil {
+		return nil, err
+	}
+	sw := &Writer{crc: crc32.New(castagnoli)}
+	sw.out = w
+	if c == Gzip {
+		sw.zw, _ = gzip.NewWriterLevel(w, gzip.BestSpeed)
+		sw.out = sw.zw
+	}
+	sw.bw = bufio.NewWriterSize(io.MultiWriter(sw.out, sw.crc), 1<<20)
+	return sw, nil
+}
+
+// Window writes the window of addresses [lo, lo+2^20) as a bitset of WindowWords words
+// (bit b of w[k] is lo + 64*k + b). Windows must come in ascending order; skipped ones are empty.
+func (sw *Writer) Window(lo uint32, w []uint64) error {
+	i := int(lo >> WindowBits)
+	if len(w) != WindowWords || lo&(1<<WindowBits-1) != 0 || i < sw.next {
+		return fmt.Errorf("snapshot: window %#x out of order", lo)
+	}
+	if err := sw.empty(i); err != nil {
+		return err
+	}
+	var n int
+	for _, word := range w {
+		n += bits.OnesCount64(word)
+	}
+	b := sw.buf[:0]
+	switch {
+	case n == 0:
+		b = append(b, 0)
+	case n <= arrayMax:
+		b = append(b, 2)
+		b = binary.LittleEndian.AppendUint32(b, uint32(n))
+		for k, word := range w {
+			for ; word != 0; word &= word - 1 {
+				b = binary.LittleEndian.AppendUint32(b, uint32(k<<6|bits.TrailingZeros64(word)))
+			}
+		}
+	default:
+		b = append(b, 1)
+		for _, word := range w {
+			b = binary.LittleEndian.AppendUint64(b, word)
+		}
+	}
+	sw.buf = b
+	sw.next, sw.n = i+1, sw.n+uint64(n)
+	_, err := sw.bw.Write(b)
+	return err
+}
+
+// empty writes empty records up to window i.
+func (sw *Writer) empty(i int) error {
+	for ; sw.next < i; sw.next++ {
+		if err := sw.bw.WriteByte(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of addresses written so far.
+func (sw *Writer) Len() uint64 { return sw.n }
+
+// Close writes the windows left as empty and the trailer, and flushes the snapshot.
+// It doesn't close the underlying writer.
+func (sw *Writer) Close() error {
+	if err := sw.empty(Windows); err != nil {
+		return err
+	}
+	var t [12]byte
+	binary.LittleEndian.PutUint64(t[:], sw.n)
+	if _, err := sw.bw.Write(t[:8]); err != nil {
+		return err
+	}
+	if err := sw.bw.Flush(); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(t[8:], sw.crc.Sum32())
+	if _, err := sw.out.Write(t[8:]); err != nil {
+		return err
+	}
+	if sw.zw != nil {
+		return sw.zw.Close()
+	}
+	return nil
+}
+
+// Reader reads a snapshot window by window.
+type Reader struct {
+	br   *bufio.Reader
+	zr   *gzip.Reader // nil unless compressed
+	crc  hash.Hash32
+	comp Compression
+	next int // the window to read next
+	n    uint64
+	buf  []byte
+}
+
+// NewReader reads the header of a snapshot from r and returns a Reader for its windows.
+func NewReader(r io.Reader) (*Reader, error) {
+	var h [headerSize]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrFormat
+		}
+		return nil, err
+	}
+	if !bytes.Equal(h[:6], magic[:]) {
+		return nil, ErrFormat
+	}
+	if v := binary.LittleEndian.Uint16(h[6:]); v != Version {
+		return nil, fmt.Errorf("snapshot: unsupported version %d", v)
+	}
+	c := Compression(h[8])
+	if int(c) >= len(compressionNames) || h[9] != WindowBits || !allZero(h[10:]) {
+		return nil, ErrFormat
+	}
+	sr := &Reader{crc: crc32.New(castagnoli), comp: c}
+	if c == Gzip {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+		sr.zr, r = zr, zr
+	}
+	sr.br = bufio.NewReaderSize(r, 1<<20)
+	return sr, nil
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Compression returns the compression of the snapshot's payload.
+func (sr *Reader) Compression() Compression { return sr.comp }
+
+// read fills p from the payload, hashing what it reads.
+func (sr *Reader) read(p []byte) error {
+	if _, err := io.ReadFull(sr.br, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	sr.crc.Write(p)
+	return nil
+}
+
+// Next reads the next window into w, which must hold WindowWords words, and returns its first address
+// and how many addresses it holds. Every window comes in turn, empty ones included; after the last one
+// Next checks the trailer and returns io.EOF (w is then unused).
+func (sr *Reader) Next(w []uint64) (lo uint32, n int, err error) { return sr.readWindow(w, false) }
+
+// Or is Next adding the window's addresses to those already in w.
+func (sr *Reader) Or(w []uint64) (lo uint32, n int, err error) { return sr.readWindow(w, true) }
+
+func (sr *Reader) readWindow(w []uint64, or bool) (lo uint32, n int, err error) {
+	if sr.next >= Windows {
+		return 0, 0, sr.trailer()
+	}
+	if len(w) != WindowWords {
+		return 0, 0, fmt.Errorf("snapshot: window of %d words", len(w))
+	}
+	lo = uint32(sr.next) << WindowBits
+	sr.next++
+	if !or {
+		clear(w)
+	}
+	var kind [1]byte
+	if err := sr.read(kind[:]); err != nil {
+		return 0, 0, err
+	}
+	switch kind[0] {
+	case 0:
+	case 1:
+		b := sr.grow(WindowWords * 8)
+		if err := sr.read(b); err != nil {
+			return 0, 0, err
+		}
+		for k := range w {
+			word := binary.LittleEndian.Uint64(b[k*8:])
+			w[k] |= word
+			n += bits.OnesCount64(word)
+		}
+	case 2:
+		var cnt [4]byte
+		if err := sr.read(cnt[:]); err != nil {
+			return 0, 0, err
+		}
+		n = int(binary.LittleEndian.Uint32(cnt[:]))
+		if n == 0 || n > arrayMax {
+			return 0, 0, ErrFormat
+		}
+		b := sr.grow(n * 4)
+		if err := sr.read(b); err != nil {
+			return 0, 0, err
+		}
+		prev := -1
+		for i := 0; i < n; i++ {
+			off := int(binary.LittleEndian.Uint32(b[i*4:]))
+			if off <= prev || off >= 1<<WindowBits {
+				return 0, 0, ErrFormat
+			}
+			w[off>>6] |= 1 << (off & 63)
+			prev = off
+		}
+	default:
+		return 0, 0, ErrFormat
+	}
+	sr.n += uint64(n)
+	return lo, n, nil
+}
+
+func (sr *Reader) grow(n int) []byte {
+	if cap(sr.buf) < n {
+		sr.buf = make([]byte, n)
+	}
+	return sr.buf[:n]
+}
+
+// trailer checks the cardinality and checksum after the last window.
+func (sr *Reader) trailer() error {
+	if sr.next > Windows {
+		return io.EOF
+	}
+	sr.next++
+	var t [12]byte
+	if err := sr.read(t[:8]); err != nil {
+		return err
+	}
+	sum := sr.crc.Sum32()
+	if _, err := io.ReadFull(sr.br, t[8:]); err != nil {
+		return fmt.Errorf("snapshot: %w", io.ErrUnexpectedEOF)
+	}
+	if binary.LittleEndian.Uint64(t[:]) != sr.n || binary.LittleEndian.Uint32(t[8:]) != sum {
+		return ErrChecksum
+	}
+	if sr.zr != nil {
+		// the gzip trailer has its own checksum: reach it
+		if _, err := sr.br.ReadByte(); err != io.EOF {
+			if err == nil {
+				return ErrFormat
+			}
+			return fmt.Errorf("snapshot: %w", err)
+		}
+	}
+	return io.EOF
+}
+
+// Len returns the number of addresses read so far: the snapshot's cardinality once Next returned io.EOF.
+func (sr *Reader) Len() uint64 { return sr.n }
@@ -0,0 +1,51 @@
+package ipcount
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Borislavv/ip-file-counter/internal/read"
+)
+
+type (
+	// Comparison is the set algebra between two inputs A and B: |A|, |B|, |A∩B|, |A\B| and |B\A|,
+	// with Union() and Jaccard(), and the line accounting of either side.
+	Comparison = read.Comparison
+	// Part is one of the sets a comparison splits the addresses of A and B into.
+	Part = read.Part
+)
+
+const (
+	PartUnion = read.PartUnion // A∪B
+	PartA     = read.PartA     // A
+	PartB     = read.PartB     // B
+	PartBoth  = read.PartBoth  // A∩B
+	PartOnlyA = read.PartOnlyA // A\B
+	PartOnlyB = read.PartOnlyB // B\A
+)
+
+// ParsePart maps a part name ("union", "a", "b", "both", "a-b", "b-a") to its Part.
+func ParsePart(s string) (Part, error) { return read.ParsePart(s) }
+
+// Compare counts the inputs of a and of b into a set of bitsets each (1 GiB in all), one side after the
+// other with Options.Readers parallel readers, and compares them. Options.PerFile doesn't apply.
+func (c *Counter) Compare(ctx context.Context, a, b []string) (Comparison, error) {
+	if len(a) == 0 || len(b) == 0 {
+		return Comparison{}, ErrNoInput
+	}
+	return read.CompareFiles(ctx, a, b, c.cfg, PartUnion, nil)
+}
+
+// CompareExport is Compare writing the addresses of part to w in ascending order once both sides are read,
+// with no memory beyond Compare's; Comparison.Exported is how many. Nothing is written if a count fails;
+// write errors come back wrapped with "export:".
+func (c *Counter) CompareExport(ctx context.Context, a, b []string, w io.Writer, format ExportFormat, part Part) (Comparison, error) {
+	if len(a) == 0 || len(b) == 0 {
+		return Comparison{}, ErrNoInput
+	}
+	if part < PartUnion || part > PartOnlyB {
+		return Comparison{}, fmt.Errorf("ipcount: unknown part %v", part)
+	}
+	return read.CompareFiles(ctx, a, b, c.cfg, part, &read.Export{W: w, Format: format})
+}
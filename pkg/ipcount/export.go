@@ -0,0 +1,61 @@
+package ipcount
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Borislavv/ip-file-counter/internal/codec"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+)
+
+// ExportFormat is how exported addresses are written.
+type ExportFormat = codec.ListFormat
+
+const (
+	ExportText    = codec.ListText    // dotted quads, one per line
+	ExportRaw     = codec.ListRaw     // 4-byte big-endian uint32s
+	ExportVarint  = codec.ListVarint  // uvarint of the gap to the previous address (the first one's is from 0)
+	ExportRoaring = codec.ListRoaring // a portable Roaring bitmap, readable by ReadRoaring and the Roaring libraries
+)
+
+// ParseExportFormat maps a format name ("text", "raw", "varint", "roaring") to its ExportFormat.
+func ParseExportFormat(s string) (ExportFormat, error) { return codec.ParseListFormat(s) }
+
+// Pick selects which of the unique addresses an export writes.
+type Pick = read.Pick
+
+const (
+	PickAll        = read.PickAll        // every address seen
+	PickDuplicates = read.PickDuplicates // addresses seen more than once
+	PickSingletons = read.PickSingletons // addresses seen exactly once
+)
+
+// ParsePick maps a pick name ("all", "duplicates", "singletons") to its Pick.
+func ParsePick(s string) (Pick, error) { return read.ParsePick(s) }
+
+// ExportFiles counts many inputs like CountFiles and then writes their unique addresses to w
+// in ascending order, encoding address ranges in parallel (ExportRoaring gathers them into a Set first). Nothing is written if the count fails;
+// write errors come back wrapped with "export:".
+func (c *Counter) ExportFiles(ctx context.Context, paths []string, w io.Writer, format ExportFormat) (Result, error) {
+	return c.ExportPick(ctx, paths, w, format, PickAll)
+}
+
+// ExportPick is ExportFiles writing only the addresses pick selects: with PickDuplicates or PickSingletons
+// the count keeps a second "seen again" bitset (512 MiB more, no counters) to tell them apart.
+// Result.Unique still counts every distinct address, Result.Exported the ones written.
+func (c *Counter) ExportPick(ctx context.Context, paths []string, w io.Writer, format ExportFormat, pick Pick) (Result, error) {
+	if len(paths) == 0 {
+		return Result{}, ErrNoInput
+	}
+	if pick < PickAll || pick > PickSingletons {
+		return Result{}, fmt.Errorf("ipcount: unknown pick %v", pick)
+	}
+	var res Result
+	e := &read.Export{W: w, Format: format, Pick: pick}
+	cfg := c.cfg
+	cfg.Stats, cfg.Export = &res.Lines, e
+	n, files, err := read.UniqueIPv4CountFilesContext(ctx, paths, cfg)
+	res.Unique, res.Files, res.Exported = n, files, e.N
+	return res, err
+}
@@ -0,0 +1,25 @@
+package ipcount
+
+import (
+	"context"
+	"io"
+
+	"github.com/Borislavv/ip-file-counter/internal/read"
+)
+
+// Filter writes the lines of paths to w in input order, keeping the first line of every IPv4 as is
+// and dropping its later repeats (uniq without sorting), with a 512 MiB bitset whatever the input size.
+// Lines that aren't an IPv4 are dropped and show up in Result.Lines; Result.Unique is the number of
+// lines kept. Blocks are parsed by Options.Readers workers and written back in order. What was written
+// before a failure stays written; write errors come back wrapped with "filter:".
+func (c *Counter) Filter(ctx context.Context, paths []string, w io.Writer) (Result, error) {
+	if len(paths) == 0 {
+		return Result{}, ErrNoInput
+	}
+	var res Result
+	cfg := c.cfg
+	cfg.Stats = &res.Lines
+	n, err := read.FilterFiles(ctx, paths, w, cfg)
+	res.Unique = n
+	return res, err
+}
@@ -0,0 +1,58 @@
+package ipcount
+
+import (
+	"context"
+
+	"github.com/Borislavv/ip-file-counter/internal/read"
+)
+
+type (
+	// Counters selects how Frequencies keeps per-IP counts.
+	Counters = read.Counters
+	// Frequencies holds the exact occurrence count of every address of a scan:
+	// Count(ip), Top(k) and Unique().
+	Frequencies = read.Freq
+	// IPCount is an address with its number of occurrences.
+	IPCount = read.IPCount
+	// Histogram is the distribution of occurrence counts from Frequencies.Histogram,
+	// with the totals of singletons (seen once) and repeaters.
+	Histogram = read.Histogram
+	// Bucket is a range of occurrence counts and the addresses seen that many times.
+	Bucket = read.Bucket
+)
+
+// DefaultBuckets are the histogram lower bounds Frequencies.Histogram(nil) uses:
+// 1, 2, 3-4, 5-10, 11-100, 101-1000, 1001-10000 and more.
+var DefaultBuckets = read.DefaultBuckets
+
+// ParseBuckets parses comma-separated bucket lower bounds, strictly ascending from 1 ("1,2,11,101").
+func ParseBuckets(s string) ([]uint64, error) { return read.ParseBuckets(s) }
+
+// Counter layouts: every one keeps exact counts, they differ in memory. Dense counters saturate
+// at 255 or 65535 and spill the rest into a map, so only heavy hitters cost extra.
+const (
+	CountersAuto   = read.CountersAuto   // a map per shard, turned into 8-bit counters once those are smaller (the default)
+	CountersSparse = read.CountersSparse // a map per shard: about 32 bytes per distinct address
+	Counters8      = read.Counters8      // a uint8 per address: 4 GiB whatever the input
+	Counters16     = read.Counters16     // a uint16 per address: 8 GiB, fewer spills when most addresses repeat a lot
+)
+
+// ParseCounters maps a counters name ("auto", "sparse", "8", "16") to its Counters.
+func ParseCounters(s string) (Counters, error) { return read.ParseCounters(s) }
+
+// Frequencies counts many inputs like CountFiles, keeping how many times every address occurs
+// as Options.Counters says, on top of the unique bitsets.
+func (c *Counter) Frequencies(ctx context.Context, paths []string) (*Frequencies, Result, error) {
+	if len(paths) == 0 {
+		return nil, Result{}, ErrNoInput
+	}
+	var res Result
+	cfg := c.cfg
+	cfg.Stats, cfg.Counters = &res.Lines, c.counters
+	f, files, err := read.IPv4FrequenciesFiles(ctx, paths, cfg)
+	if err != nil {
+		return nil, res, err
+	}
+	res.Unique, res.Files = f.Unique(), files
+	return f, res, nil
+}
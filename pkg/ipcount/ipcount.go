@@ -0,0 +1,116 @@
+// Package ipcount counts unique IPv4 addresses in very large line-oriented inputs
+// (one address per line), using parallel readers and a 2^32-bit set split across shards.
+//
+// A Counter is built once from Options and can run any number of counts, each with its own
+// set of bitsets (512 MiB, allocated once the input is open):
+//
+//	c, err := ipcount.New(ipcount.Options{Readers: 8})
+//	if err != nil {
+//		return err // *OptionError
+//	}
+//	res, err := c.CountFile(ctx, "/var/log/ips.txt")
+//
+// Failures come back as typed errors: *OptionError from New, *ReadError for I/O failures with the
+// path and offset, *LineError for a malformed line in strict mode, ErrDirectUnsupported,
+// and ctx.Err() once the context is done.
+package ipcount
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/Borislavv/ip-file-counter/internal/read"
+)
+
+type (
+	IOMode           = read.IOMode
+	ShardLayout      = read.ShardLayout
+	Progress         = read.Progress
+	ProgressSnapshot = read.ProgressSnapshot
+	LineStats        = read.LineStats
+	InvalidLine      = read.InvalidLine
+	FileCount        = read.FileCount
+
+	ReadError = read.ReadError // an input failed to read at a byte offset
+	LineError = read.LineError // a strict count met a malformed line
+)
+
+// Reader modes for plain-text files.
+const (
+	IOPread  = read.IOPread
+	IOMmap   = read.IOMmap
+	IODirect = read.IODirect
+	IOURing  = read.IOURing
+)
+
+// Shard layouts: LayoutModulo spreads neighbouring addresses over all shards, which keeps the load even
+// however skewed the traffic; LayoutRange gives every shard a contiguous address range (rounding Shards
+// up to a power of two), which keeps neighbours together and makes ordered walks cheap.
+const (
+	LayoutModulo = read.LayoutModulo
+	LayoutRange  = read.LayoutRange
+)
+
+// Stdin is the CountFiles input name that stands for the process standard input.
+const Stdin = read.Stdin
+
+var (
+	// ErrDirectUnsupported is returned for IODirect where O_DIRECT reads are not available.
+	ErrDirectUnsupported = read.ErrDirectUnsupported
+	// ErrNoInput is returned by CountFiles without any path.
+	ErrNoInput = errors.New("ipcount: no input")
+)
+
+// ParseIOMode maps a mode name ("pread", "mmap", "direct", "uring") to its IOMode.
+func ParseIOMode(s string) (IOMode, error) { return read.ParseIOMode(s) }
+
+// ParseShardLayout maps a layout name ("modulo", "range") to its ShardLayout.
+func ParseShardLayout(s string) (ShardLayout, error) { return read.ParseShardLayout(s) }
+
+// ExpandInputs resolves plain paths, shell-style globs and directories into a flat list of files,
+// walking directories recursively if asked to. Stdin is passed through.
+func ExpandInputs(args []string, recursive bool) ([]string, error) {
+	return read.ExpandInputs(args, recursive)
+}
+
+// Result is the outcome of a count.
+type Result struct {
+	Unique   uint64      // distinct IPv4s across all inputs
+	Files    []FileCount // per-file breakdown of CountFiles with Options.PerFile set
+	Lines    LineStats   // line accounting, with samples of invalid lines
+	Exported uint64      // addresses written by ExportFiles or ExportPick
+	Loaded   uint64      // addresses of the snapshot CountSnapshot started from: Unique - Loaded are newly seen
+}
+
+// Counter counts unique IPv4s with a fixed configuration. It holds no state between counts,
+// so one Counter may run several counts concurrently (each one allocating its own bitsets).
+type Counter struct {
+	cfg      read.Config
+	counters Counters // only for Frequencies, so plain counts don't allocate counters
+}
+
+// New validates opts and returns a Counter using them.
+func New(opts Options) (*Counter, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return &Counter{cfg: opts.config(), counters: opts.Counters}, nil
+}
+
+// CountFile counts one file: plain text read with the configured reader mode, or gzip, BGZF, bzip2
+// and zlib decoded on the fly. Pipes and other non-regular files are streamed.
+func (c *Counter) CountFile(ctx context.Context, path string) (Result, error) {
+	return c.CollectFile(ctx, path, nil)
+}
+
+// CountReader counts a sequential stream, decompressing it if its magic bytes say so.
+func (c *Counter) CountReader(ctx context.Context, r io.Reader) (Result, error) {
+	return c.CollectReader(ctx, r, nil)
+}
+
+// CountFiles counts many inputs into one shared set, one after another; Stdin stands for the standard input.
+// With Options.PerFile set, Result.Files holds every file's own unique count and how many uniques it added first.
+func (c *Counter) CountFiles(ctx context.Context, paths []string) (Result, error) {
+	return c.CollectFiles(ctx, paths, nil)
+}
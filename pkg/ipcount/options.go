@@ -0,0 +1,97 @@
+package ipcount
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Borislavv/ip-file-counter/internal/read"
+)
+
+// Upper bounds of Options fields; anything above is a mistake rather than a tuning choice.
+const (
+	MaxShards  = 1 << 16
+	MaxReaders = 1 << 12
+	MaxBufMB   = 1 << 10
+	MaxProbeKB = 1 << 10
+)
+
+// Options configure a Counter. The zero value is valid: zero Shards and Readers scale with GOMAXPROCS,
+// zero BufMB and ProbeKB pick read.DefaultBufMB and read.DefaultProbeKB.
+type Options struct {
+	Shards  int         // aggregation shards, up to MaxShards
+	Readers int         // parallel readers per input, up to MaxReaders
+	BufMB   int         // per-reader block size in MiB, up to MaxBufMB
+	ProbeKB int         // segment align probe window in KiB, up to MaxProbeKB
+	IO      IOMode      // how plain-text files are read
+	Layout  ShardLayout // how addresses are spread over shards
+	PerFile bool        // CountFiles also reports every file's own unique and first-seen counts (doubles bitset memory)
+
+	Counters Counters // per-IP count storage of Frequencies: zero means CountersAuto
+
+	RetryAttempts int           // retries of reads failing with EINTR/EAGAIN
+	RetryBackoff  time.Duration // pause before the first retry, doubled for every next one
+
+	Strict   bool      // fail with a *LineError on the first malformed line
+	Samples  int       // invalid lines kept in Result.Lines.Samples: 0 means 10, negative none
+	Progress *Progress // if set, updated as the count goes; then run one count at a time
+}
+
+// OptionError reports an Options field with an unusable value.
+type OptionError struct {
+	Field  string
+	Value  any
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("ipcount: invalid %s %v: %s", e.Field, e.Value, e.Reason)
+}
+
+// validate checks every field and returns the first bad one.
+func (o Options) validate() error {
+	for _, f := range []struct {
+		field  string
+		v, max int
+	}{
+		{"Shards", o.Shards, MaxShards},
+		{"Readers", o.Readers, MaxReaders},
+		{"BufMB", o.BufMB, MaxBufMB},
+		{"ProbeKB", o.ProbeKB, MaxProbeKB},
+	} {
+		if f.v < 0 || f.v > f.max {
+			return &OptionError{Field: f.field, Value: f.v, Reason: fmt.Sprintf("must be within [0, %d]", f.max)}
+		}
+	}
+	if o.RetryAttempts < 0 {
+		return &OptionError{Field: "RetryAttempts", Value: o.RetryAttempts, Reason: "must not be negative"}
+	}
+	if o.RetryBackoff < 0 {
+		return &OptionError{Field: "RetryBackoff", Value: o.RetryBackoff, Reason: "must not be negative"}
+	}
+	if _, err := read.ParseIOMode(o.IO.String()); err != nil {
+		return &OptionError{Field: "IO", Value: o.IO, Reason: "unknown io mode"}
+	}
+	if _, err := read.ParseCounters(o.Counters.String()); err != nil {
+		return &OptionError{Field: "Counters", Value: o.Counters, Reason: "unknown counters"}
+	}
+	if _, err := read.ParseShardLayout(o.Layout.String()); err != nil {
+		return &OptionError{Field: "Layout", Value: o.Layout, Reason: "unknown shard layout"}
+	}
+	return nil
+}
+
+func (o Options) config() read.Config {
+	return read.Config{
+		Shards:   o.Shards,
+		Readers:  o.Readers,
+		BufMB:    o.BufMB,
+		ProbeKB:  o.ProbeKB,
+		IO:       o.IO,
+		Layout:   o.Layout,
+		PerFile:  o.PerFile,
+		Retry:    read.RetryPolicy{Attempts: o.RetryAttempts, Backoff: o.RetryBackoff},
+		Strict:   o.Strict,
+		Samples:  o.Samples,
+		Progress: o.Progress,
+	}
+}
@@ -0,0 +1,39 @@
+package ipcount
+
+import (
+	"context"
+
+	"github.com/Borislavv/ip-file-counter/internal/read"
+)
+
+type (
+	// Overlap is the pairwise overlap of N inputs: Matrix[i][j] is how many addresses files i and j share,
+	// Files[i] has the unique count of file i and how many of them no other file holds.
+	Overlap = read.Overlap
+	// OverlapFile is one input of an Overlap, with its line accounting.
+	OverlapFile = read.OverlapFile
+)
+
+// BitsetBytes is the memory of one dense bitset over every IPv4 address: 512 MiB.
+const BitsetBytes = 1 << 29
+
+// DefaultOverlapBudget is the bitset memory Counter.Overlap keeps resident for a zero budget: 4 GiB.
+const DefaultOverlapBudget = 8 * BitsetBytes
+
+// ErrOverlapStdin is returned by Counter.Overlap for stdin among the inputs: it may have to read them more than once.
+var ErrOverlapStdin = read.ErrOverlapStdin
+
+// Overlap counts every input into a bitset of its own and returns the N×N matrix of pairwise intersections
+// with each file's count of addresses no other file holds. budget bounds the bitset memory resident at once
+// (at least 3 bitsets unless every file fits): if the N files fit they're read once, else they take turns
+// staying resident while the others are streamed past them, every input being read once per turn
+// (Overlap.Passes). Options.PerFile doesn't apply.
+func (c *Counter) Overlap(ctx context.Context, paths []string, budget uint64) (*Overlap, error) {
+	if len(paths) == 0 {
+		return nil, ErrNoInput
+	}
+	if budget == 0 {
+		budget = DefaultOverlapBudget
+	}
+	return read.OverlapFiles(ctx, paths, c.cfg, int(min(budget/BitsetBytes, 1<<20)))
+}
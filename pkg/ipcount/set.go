@@ -0,0 +1,66 @@
+package ipcount
+
+import (
+	"context"
+	"io"
+	"net/netip"
+
+	"github.com/Borislavv/ip-file-counter/internal/ipset"
+	"github.com/Borislavv/ip-file-counter/internal/read"
+)
+
+// Set is an in-memory set of IPv4 addresses, held as uint32s (A<<24 | B<<16 | C<<8 | D).
+// Sparse address groups are kept as sorted arrays, dense ones as bitmaps, so a set of a few
+// addresses is small and a full one takes 512 MiB. The zero value is an empty set.
+type Set = ipset.Set
+
+// NewSet returns an empty set.
+func NewSet() *Set { return ipset.New() }
+
+// Addr converts a uint32 address to a netip.Addr.
+func Addr(ip uint32) netip.Addr { return ipset.Addr(ip) }
+
+// FromAddr converts an IPv4 or IPv4-mapped IPv6 address to its uint32 form; ok is false for any other address.
+func FromAddr(a netip.Addr) (ip uint32, ok bool) { return ipset.FromAddr(a) }
+
+// ErrRoaring is returned by ReadRoaring for input that isn't a well-formed portable Roaring bitmap.
+var ErrRoaring = ipset.ErrRoaring
+
+// ReadRoaring reads a set written in the portable Roaring format (as by Set.WriteRoaring, or the
+// C, Java, Go and Python Roaring libraries' serialization of a 32-bit bitmap), reading no further than its end.
+func ReadRoaring(r io.Reader) (*Set, error) { return ipset.ReadRoaring(r) }
+
+// CollectFile counts path like CountFile and adds every address it holds to dst (if not nil),
+// so one set can be built from several scans and queried afterwards. Result.Unique
+// is the count of this scan alone. On error dst is left as it was.
+func (c *Counter) CollectFile(ctx context.Context, path string, dst *Set) (Result, error) {
+	var res Result
+	cfg := c.cfg
+	cfg.Stats, cfg.Set = &res.Lines, dst
+	n, err := read.UniqueIPv4CountContext(ctx, path, cfg)
+	res.Unique = n
+	return res, err
+}
+
+// CollectReader counts a stream like CountReader and adds every address it holds to dst.
+func (c *Counter) CollectReader(ctx context.Context, r io.Reader, dst *Set) (Result, error) {
+	var res Result
+	cfg := c.cfg
+	cfg.Stats, cfg.Set = &res.Lines, dst
+	n, err := read.UniqueIPv4CountReaderContext(ctx, r, cfg)
+	res.Unique = n
+	return res, err
+}
+
+// CollectFiles counts many inputs like CountFiles and adds every address they hold to dst.
+func (c *Counter) CollectFiles(ctx context.Context, paths []string, dst *Set) (Result, error) {
+	if len(paths) == 0 {
+		return Result{}, ErrNoInput
+	}
+	var res Result
+	cfg := c.cfg
+	cfg.Stats, cfg.Set = &res.Lines, dst
+	n, files, err := read.UniqueIPv4CountFilesContext(ctx, paths, cfg)
+	res.Unique, res.Files = n, files
+	return res, err
+}
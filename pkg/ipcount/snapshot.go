@@ -0,0 +1,65 @@
+package ipcount
+
+import (
+	"context"
+	"io"
+
+	"github.com/Borislavv/ip-file-counter/internal/read"
+	"github.com/Borislavv/ip-file-counter/internal/snapshot"
+)
+
+// SnapshotCompression is how a saved snapshot's payload is compressed.
+type SnapshotCompression = snapshot.Compression
+
+const (
+	SnapshotNone = snapshot.None
+	SnapshotGzip = snapshot.Gzip
+)
+
+// ParseSnapshotCompression maps a compression name ("none", "gzip") to its SnapshotCompression.
+func ParseSnapshotCompression(s string) (SnapshotCompression, error) {
+	return snapshot.ParseCompression(s)
+}
+
+// Snapshot errors: a file that isn't a snapshot, or one whose payload doesn't match its checksum.
+var (
+	ErrSnapshotFormat   = snapshot.ErrFormat
+	ErrSnapshotChecksum = snapshot.ErrChecksum
+)
+
+// Snapshot carries a count's set over from one count to the next as an .ipset snapshot: the 2^32-bit set
+// window by window in address order, behind a versioned header and followed by its cardinality and a CRC-32C.
+type Snapshot struct {
+	Load        io.Reader           // if set, the count starts from the set of this snapshot or portable Roaring bitmap
+	Save        io.Writer           // if set, the final set is written here once the count succeeds
+	Compression SnapshotCompression // of Save
+}
+
+// CountSnapshot counts many inputs like CountFiles, starting from snap.Load if given and saving the
+// final set to snap.Save if given. Result.Unique counts the loaded addresses too, Result.Loaded
+// how many there were. Load and save errors come back wrapped with "load:" and "save:". With snap.Load
+// given, paths may be empty: the set is the loaded one, which re-saves or converts a snapshot.
+func (c *Counter) CountSnapshot(ctx context.Context, paths []string, snap Snapshot) (Result, error) {
+	if len(paths) == 0 && snap.Load == nil {
+		return Result{}, ErrNoInput
+	}
+	var res Result
+	sn := &read.Snapshots{Load: snap.Load, Save: snap.Save, Compression: snap.Compression}
+	cfg := c.cfg
+	cfg.Stats, cfg.Snapshots = &res.Lines, sn
+	n, files, err := read.UniqueIPv4CountFilesContext(ctx, paths, cfg)
+	res.Unique, res.Files, res.Loaded = n, files, sn.Loaded
+	return res, err
+}
+
+// MergeSnapshots returns the cardinality of the union of the snapshots at paths, read in parallel and
+// in lockstep with memory bounded by a few windows per core, and writes the union to w as a snapshot
+// if w is not nil. Every input's checksum is verified; errors name the path they concern. Past 64 inputs,
+// the union so far is folded through a temporary snapshot in os.TempDir. Roaring bitmaps aren't taken: load
+// one with CountSnapshot and no paths to save it as a snapshot first.
+func MergeSnapshots(ctx context.Context, paths []string, w io.Writer, c SnapshotCompression) (uint64, error) {
+	if len(paths) == 0 {
+		return 0, ErrNoInput
+	}
+	return snapshot.MergeFiles(ctx, paths, w, c)
+}